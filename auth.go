@@ -0,0 +1,252 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Adds the credentials to the requests sent by a Client.
+//
+type Authenticator interface {
+	// Add the credentials to `req`.
+	Authenticate(req *http.Request) error
+	// Called when the REST API rejected the credentials `failed` was sent
+	// with. Return true if the credentials changed since, and the request is
+	// worth sending again.
+	Refresh(failed *http.Request) (bool, error)
+}
+
+func (c *Client) authenticator() Authenticator {
+	if c.Auth == nil {
+		return &BasicAuth{Username: c.Username, Password: c.Password}
+	}
+
+	return c.Auth
+}
+
+//
+// HTTP basic authentication, the default for Client.
+//
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+func (a *BasicAuth) Refresh(failed *http.Request) (bool, error) {
+	return false, nil
+}
+
+//
+// Static bearer token.
+//
+type BearerToken string
+
+func (t BearerToken) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+func (t BearerToken) Refresh(failed *http.Request) (bool, error) {
+	return false, nil
+}
+
+//
+// Credentials returned by a CredentialProvider: either a username and an
+// access key for basic authentication, or a bearer token.
+//
+type Credentials struct {
+	Username  string `json:"username"`
+	AccessKey string `json:"access_key"`
+	Token     string `json:"token"`
+}
+
+//
+// Add the credentials to the Authorization header of `req`.
+//
+func (c Credentials) authenticate(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		req.SetBasicAuth(c.Username, c.AccessKey)
+	}
+}
+
+//
+// Return the Authorization header sent with the credentials.
+//
+func (c Credentials) authorization() string {
+	var req = http.Request{Header: make(http.Header)}
+	c.authenticate(&req)
+	return req.Header.Get("Authorization")
+}
+
+//
+// Authenticator fetching the credentials with Fetch, a vault helper for
+// instance. They are cached for TTL (forever if zero), and fetched again when
+// the REST API rejects them. Fetch is never called concurrently: the requests
+// needing credentials while they are fetched wait for them.
+//
+type CredentialProvider struct {
+	Fetch func() (Credentials, error)
+	TTL   time.Duration
+
+	mutex       sync.Mutex
+	credentials *Credentials
+	expires     time.Time
+}
+
+//
+// Return the cached credentials, fetching them if needed.
+//
+func (p *CredentialProvider) get() (Credentials, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.credentials != nil &&
+		(p.TTL == 0 || time.Now().Before(p.expires)) {
+		return *p.credentials, nil
+	}
+
+	credentials, err := p.Fetch()
+	if err != nil {
+		return credentials, err
+	}
+	p.credentials = &credentials
+	p.expires = time.Now().Add(p.TTL)
+
+	return credentials, nil
+}
+
+func (p *CredentialProvider) Authenticate(req *http.Request) error {
+	credentials, err := p.get()
+	if err != nil {
+		return err
+	}
+	credentials.authenticate(req)
+
+	return nil
+}
+
+//
+// Fetch the credentials again, unless they changed since `failed` was sent,
+// and report if they differ from the ones of `failed`. The concurrent
+// requests rejected with the same credentials only fetch them once.
+//
+func (p *CredentialProvider) Refresh(failed *http.Request) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var sent = failed.Header.Get("Authorization")
+	if p.credentials != nil && p.credentials.authorization() != sent {
+		return true, nil
+	}
+
+	credentials, err := p.Fetch()
+	if err != nil {
+		return false, err
+	}
+	p.credentials = &credentials
+	p.expires = time.Now().Add(p.TTL)
+
+	return credentials.authorization() != sent, nil
+}
+
+//
+// Parse credentials from either a JSON document with the fields of
+// Credentials, or a "username:access-key" line.
+//
+func ParseCredentials(data []byte) (credentials Credentials, err error) {
+	data = bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(data, []byte("{")) {
+		if err = json.Unmarshal(data, &credentials); err != nil {
+			return credentials, fmt.Errorf("couldn't decode credentials: %s", err)
+		}
+	} else if i := bytes.IndexByte(data, ':'); i > 0 {
+		credentials.Username = string(data[:i])
+		credentials.AccessKey = string(data[i+1:])
+	}
+
+	if credentials.Token == "" &&
+		(credentials.Username == "" || credentials.AccessKey == "") {
+		return credentials, fmt.Errorf(
+			"no credentials found, expected a JSON document or username:access-key")
+	}
+
+	return credentials, nil
+}
+
+//
+// Return a CredentialProvider.Fetch function reading the credentials from the
+// file at `path`, see ParseCredentials for the format.
+//
+func FileCredentials(path string) func() (Credentials, error) {
+	return func() (Credentials, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Credentials{}, err
+		}
+
+		return ParseCredentials(data)
+	}
+}
+
+//
+// How long CommandCredentials lets the command run.
+//
+const DefaultCommandTimeout = 30 * time.Second
+
+//
+// Return a CredentialProvider.Fetch function running a command, and reading
+// the credentials from its output, see ParseCredentials for the format. The
+// command is killed after DefaultCommandTimeout.
+//
+func CommandCredentials(name string, args ...string) func() (Credentials, error) {
+	return CommandCredentialsWithTimeout(DefaultCommandTimeout, name, args...)
+}
+
+//
+// Same as CommandCredentials, but kill the command after `timeout`. Fetch
+// holds the lock of the CredentialProvider: a hung command would block every
+// request.
+//
+func CommandCredentialsWithTimeout(
+	timeout time.Duration, name string, args ...string,
+) func() (Credentials, error) {
+	return func() (Credentials, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var stderr bytes.Buffer
+		var cmd = exec.CommandContext(ctx, name, args...)
+		cmd.Stderr = &stderr
+		// Don't wait for the children still holding the output
+		cmd.WaitDelay = time.Second
+
+		out, err := cmd.Output()
+		if ctx.Err() != nil {
+			return Credentials{}, fmt.Errorf(
+				"%s timed out after %s", name, timeout)
+		} else if err != nil {
+			return Credentials{}, fmt.Errorf(
+				"%s failed: %s: %s",
+				name, err, strings.TrimSpace(stderr.String()))
+		}
+
+		return ParseCredentials(out)
+	}
+}
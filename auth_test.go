@@ -0,0 +1,291 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answer 401 unless the request is authenticated with `authorization`, and
+// record the Authorization header of every request.
+func authServer(authorization *string, seen *[]string) *httptest.Server {
+	return httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var header = r.Header.Get("Authorization")
+			*seen = append(*seen, header)
+			if header != *authorization {
+				http.Error(w, `{"error": "bad credentials"}`, 401)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			if r.Method == "POST" && len(body) == 0 {
+				http.Error(w, `{"error": "no body"}`, 400)
+				return
+			}
+			io.WriteString(w, `{"id": "fakeid", "status": "running"}`)
+		}))
+}
+
+func TestClientDefaultBasicAuth(t *testing.T) {
+	var expected = "Basic dXNlcm5hbWU6cGFzc3dvcmQ="
+	var seen []string
+	var server = authServer(&expected, &seen)
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Errorf("client.Status errored %+v\n", err)
+	}
+}
+
+func TestClientBearerToken(t *testing.T) {
+	var expected = "Bearer s3cr3t"
+	var seen []string
+	var server = authServer(&expected, &seen)
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Auth:     BearerToken("s3cr3t"),
+	}
+
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Errorf("client.Status errored %+v\n", err)
+	}
+}
+
+func TestClientBadCredentialsNoRetry(t *testing.T) {
+	var expected = "Bearer other"
+	var seen []string
+	var server = authServer(&expected, &seen)
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Auth:     BearerToken("s3cr3t"),
+	}
+
+	_, err := client.Status("fakeid")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 401 {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if len(seen) != 1 {
+		t.Errorf("Static credentials were sent %d times", len(seen))
+	}
+}
+
+// Keys rotated while the client runs are picked up on the next 401
+func TestCredentialProviderRotation(t *testing.T) {
+	var expected = "Bearer one"
+	var seen []string
+	var server = authServer(&expected, &seen)
+	defer server.Close()
+
+	var token = "one"
+	var fetches = 0
+	var provider = &CredentialProvider{
+		Fetch: func() (Credentials, error) {
+			fetches += 1
+			return Credentials{Token: token}, nil
+		},
+	}
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Auth:     provider,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Status("fakeid"); err != nil {
+			t.Fatalf("client.Status errored %+v\n", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Credentials fetched %d times, expected cached", fetches)
+	}
+
+	// Rotate the key: the first request fails, and is sent again
+	expected = "Bearer two"
+	token = "two"
+	if _, err := client.CreateTunnelWithTimeout(&Request{}, time.Second); err != nil {
+		t.Fatalf("client.CreateWithTimeout errored %+v\n", err)
+	}
+	if fetches != 2 {
+		t.Errorf("Credentials fetched %d times, expected 2", fetches)
+	}
+	var last = seen[len(seen)-3:]
+	if last[0] != "Bearer one" || last[1] != "Bearer two" || last[2] != "Bearer two" {
+		t.Errorf("Invalid authorization headers: %v", last)
+	}
+}
+
+// Requests rejected together fetch the credentials once, and are all sent
+// again with the new ones
+func TestCredentialProviderParallelRefresh(t *testing.T) {
+	const parallel = 10
+
+	var mutex sync.Mutex
+	var rejected = 0
+	var allRejected = make(chan struct{})
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "Bearer two" {
+				io.WriteString(w, `{"status": "running"}`)
+				return
+			}
+			// Answer the 401s once every request got one
+			mutex.Lock()
+			rejected += 1
+			if rejected == parallel {
+				close(allRejected)
+			}
+			mutex.Unlock()
+			select {
+			case <-allRejected:
+			case <-time.After(time.Second):
+			}
+			http.Error(w, `{"error": "bad credentials"}`, 401)
+		}))
+	defer server.Close()
+
+	var tokens = []string{"one", "two", "three"}
+	var fetches = 0
+	var provider = &CredentialProvider{
+		Fetch: func() (Credentials, error) {
+			fetches += 1
+			return Credentials{Token: tokens[fetches-1]}, nil
+		},
+	}
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Auth:     provider,
+	}
+
+	var req, _ = http.NewRequest("GET", "http://localhost/", nil)
+	provider.Authenticate(req)
+
+	var wg sync.WaitGroup
+	var errs = make(chan error, parallel)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Status("fakeid")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("client.Status errored %+v\n", err)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("Credentials fetched %d times, expected 2", fetches)
+	}
+}
+
+func TestCredentialProviderTTL(t *testing.T) {
+	var fetches = 0
+	var provider = &CredentialProvider{
+		Fetch: func() (Credentials, error) {
+			fetches += 1
+			return Credentials{Username: "username", AccessKey: "key"}, nil
+		},
+		TTL: time.Millisecond,
+	}
+
+	var req, _ = http.NewRequest("GET", "http://localhost/", nil)
+	provider.Authenticate(req)
+	time.Sleep(2 * time.Millisecond)
+	provider.Authenticate(req)
+
+	if fetches != 2 {
+		t.Errorf("Credentials fetched %d times, expected 2", fetches)
+	}
+	if username, key, ok := req.BasicAuth(); !ok ||
+		username != "username" || key != "key" {
+		t.Errorf("Invalid basic authentication %s %s", username, key)
+	}
+
+	// Unchanged credentials aren't worth a retry
+	if retry, err := provider.Refresh(req); retry || err != nil {
+		t.Errorf("Refresh returned %v, %v", retry, err)
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "credentials")
+
+	for content, expected := range map[string]Credentials{
+		"username:key\n": {Username: "username", AccessKey: "key"},
+		`{"username": "username", "access_key": "key"}`: {
+			Username: "username", AccessKey: "key",
+		},
+		`{"token": "s3cr3t"}`: {Token: "s3cr3t"},
+	} {
+		os.WriteFile(path, []byte(content), 0600)
+		credentials, err := FileCredentials(path)()
+		if err != nil {
+			t.Errorf("FileCredentials errored %+v\n", err)
+		}
+		if credentials != expected {
+			t.Errorf("Invalid credentials %+v for %q", credentials, content)
+		}
+	}
+
+	os.WriteFile(path, []byte("garbage"), 0600)
+	_, err := FileCredentials(path)()
+	if err == nil || !strings.HasPrefix(err.Error(), "no credentials found") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestCommandCredentials(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+
+	credentials, err := CommandCredentials("/bin/sh", "-c", "echo username:key")()
+	if err != nil {
+		t.Errorf("CommandCredentials errored %+v\n", err)
+	}
+	if credentials.Username != "username" || credentials.AccessKey != "key" {
+		t.Errorf("Invalid credentials %+v", credentials)
+	}
+
+	_, err = CommandCredentials("/bin/sh", "-c", "echo oops >&2; exit 1")()
+	if err == nil || !strings.HasSuffix(err.Error(), ": oops") {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	// A hung helper doesn't block the requests forever, even when its
+	// children keep the output open
+	var start = time.Now()
+	_, err = CommandCredentialsWithTimeout(
+		100*time.Millisecond, "/bin/sh", "-c", "sleep 10 & sleep 10")()
+	if err == nil || err.Error() != "/bin/sh timed out after 100ms" {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("The command ran for %s", elapsed)
+	}
+}
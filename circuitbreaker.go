@@ -0,0 +1,212 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//
+// State of a CircuitBreaker.
+//
+type CircuitState int
+
+const (
+	// The requests are sent
+	CircuitClosed CircuitState = iota
+	// The requests fail right away
+	CircuitOpen
+	// One request is sent to probe the REST API, the others fail right away
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+//
+// Matches the errors returned while the circuit is open, with errors.Is.
+//
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+//
+// Error returned instead of sending a request while the circuit is open.
+// Until is when the next request will be let through to probe the REST API.
+//
+type CircuitOpenError struct {
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open until %s",
+		e.Until.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+//
+// Return how long to wait before retrying after `err`: until the circuit
+// lets requests through again if it's open, `fallback` otherwise.
+//
+func retryDelay(err error, fallback time.Duration) time.Duration {
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		if delay := time.Until(open.Until); delay > fallback {
+			return delay
+		}
+	}
+	return fallback
+}
+
+//
+// Circuit breaker of the requests of a Client, set it in Client.Breaker. It's
+// safe to use across goroutines.
+//
+// The circuit opens after Threshold consecutive failures (5 if zero): the
+// requests that couldn't connect or got a 5xx status. While it's open the
+// requests fail with a CircuitOpenError without being sent. After Cooldown (30
+// seconds if zero) it goes half-open and lets a single request through: the
+// circuit closes if it succeeds, and opens again otherwise.
+//
+// OnStateChange, if not nil, is called on every change of state.
+//
+type CircuitBreaker struct {
+	Threshold     int
+	Cooldown      time.Duration
+	OnStateChange func(from, to CircuitState)
+
+	mutex    sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold <= 0 {
+		return 5
+	}
+	return b.Threshold
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return 30 * time.Second
+	}
+	return b.Cooldown
+}
+
+//
+// Return the current state of the circuit.
+//
+func (b *CircuitBreaker) State() CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cooldown() {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+//
+// Change the state, and return the callback to call once unlocked.
+//
+func (b *CircuitBreaker) setState(to CircuitState) func() {
+	var from = b.state
+	b.state = to
+	if to == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+	if from == to || b.OnStateChange == nil {
+		return func() {}
+	}
+	return func() { b.OnStateChange(from, to) }
+}
+
+//
+// Return an error if the request can't be sent.
+//
+func (b *CircuitBreaker) allow() error {
+	b.mutex.Lock()
+	var notify = func() {}
+	defer func() { notify() }()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		var until = b.openedAt.Add(b.cooldown())
+		if time.Now().Before(until) {
+			return &CircuitOpenError{Until: until}
+		}
+		notify = b.setState(CircuitHalfOpen)
+		b.probing = true
+	case CircuitHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{Until: time.Now().Add(b.cooldown())}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+//
+// Record the outcome of a request.
+//
+func (b *CircuitBreaker) record(failed bool) {
+	b.mutex.Lock()
+	var notify = func() {}
+	defer func() { notify() }()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.probing = false
+	}
+	if !failed {
+		b.failures = 0
+		notify = b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures += 1
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold() {
+		notify = b.setState(CircuitOpen)
+	}
+}
+
+//
+// Return the breaker as a middleware.
+//
+func (b *CircuitBreaker) Middleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if err := b.allow(); err != nil {
+				return nil, err
+			}
+			resp, err := next(req)
+			// Canceled requests don't tell anything about the REST API
+			if err != nil && req.Context().Err() != nil {
+				b.mutex.Lock()
+				if b.state == CircuitHalfOpen {
+					b.probing = false
+				}
+				b.mutex.Unlock()
+				return resp, err
+			}
+			b.record(err != nil || resp.StatusCode >= 500)
+			return resp, err
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Server answering with the status code returned by `code`, and counting the
+// requests in `count`
+func countingServer(count *int32, code func() int) *httptest.Server {
+	return httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(count, 1)
+			if status := code(); status != 200 {
+				http.Error(w, "unavailable", status)
+				return
+			}
+			io.WriteString(w, statusRunningJSON)
+		}))
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	var count int32
+	var server = countingServer(&count, func() int { return 503 })
+	defer server.Close()
+
+	var changes []string
+	var breaker = &CircuitBreaker{
+		Threshold: 3,
+		Cooldown:  time.Hour,
+		OnStateChange: func(from, to CircuitState) {
+			changes = append(changes, from.String()+" -> "+to.String())
+		},
+	}
+	var client = Client{BaseURL: server.URL, Username: "username", Breaker: breaker}
+
+	for i := 0; i < 3; i++ {
+		var httpErr *HTTPError
+		if _, err := client.Status("fakeid"); !errors.As(err, &httpErr) {
+			t.Errorf("Request %d: invalid error %v", i, err)
+		}
+	}
+	if breaker.State() != CircuitOpen {
+		t.Errorf("Invalid state %s", breaker.State())
+	}
+
+	// Fails fast without sending the request
+	_, err := client.Status("fakeid")
+	var open *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &open) {
+		t.Fatalf("Invalid error %v", err)
+	}
+	if until := time.Until(open.Until); until < 59*time.Minute {
+		t.Errorf("Circuit open until %s", open.Until)
+	}
+	if atomic.LoadInt32(&count) != 3 {
+		t.Errorf("%d requests sent", count)
+	}
+	if len(changes) != 1 || changes[0] != "closed -> open" {
+		t.Errorf("Invalid state changes %v", changes)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	var count int32
+	var status int32 = 500
+	var server = countingServer(&count, func() int {
+		return int(atomic.LoadInt32(&status))
+	})
+	defer server.Close()
+
+	var changes []string
+	var breaker = &CircuitBreaker{
+		Threshold: 1,
+		Cooldown:  20 * time.Millisecond,
+		OnStateChange: func(from, to CircuitState) {
+			changes = append(changes, to.String())
+		},
+	}
+	var client = Client{BaseURL: server.URL, Username: "username", Breaker: breaker}
+
+	client.Status("fakeid")
+	time.Sleep(30 * time.Millisecond)
+	if breaker.State() != CircuitHalfOpen {
+		t.Errorf("Invalid state %s", breaker.State())
+	}
+
+	// The probe fails and opens the circuit again
+	client.Status("fakeid")
+	if _, err := client.Status("fakeid"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Invalid error %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	atomic.StoreInt32(&status, 200)
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Errorf("client.Status errored %+v\n", err)
+	}
+	if breaker.State() != CircuitClosed {
+		t.Errorf("Invalid state %s", breaker.State())
+	}
+	if atomic.LoadInt32(&count) != 3 {
+		t.Errorf("%d requests sent", count)
+	}
+
+	var expected = []string{"open", "half-open", "open", "half-open", "closed"}
+	if len(changes) != len(expected) {
+		t.Fatalf("Invalid state changes %v", changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Invalid state changes %v", changes)
+		}
+	}
+}
+
+// A single request probes the REST API at once
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	var breaker = CircuitBreaker{Threshold: 1, Cooldown: time.Millisecond}
+	breaker.record(true)
+	time.Sleep(2 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if breaker.allow() == nil {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 1 {
+		t.Errorf("%d probes allowed", allowed)
+	}
+}
+
+// Only the connection errors and the 5xx statuses are failures
+func TestCircuitBreakerFailures(t *testing.T) {
+	var server = multiResponseServer([]R{errorResponse(404, "not found")})
+	defer server.Close()
+
+	var breaker = &CircuitBreaker{Threshold: 1}
+	var client = Client{BaseURL: server.URL, Username: "username", Breaker: breaker}
+	client.Status("fakeid")
+	if breaker.State() != CircuitClosed {
+		t.Errorf("Opened on a 404")
+	}
+
+	server.Close()
+	client.Status("fakeid")
+	if breaker.State() != CircuitOpen {
+		t.Errorf("Didn't open on a connection error")
+	}
+}
+
+func TestTunnelWaitTransientErrors(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		errorResponse(502, "Bad Gateway"),
+		stringResponse(statusRunningJSON),
+	})
+	defer server.Close()
+
+	var client = Client{BaseURL: server.URL, Username: "username"}
+	if _, err := client.CreateTunnelWithTimeout(&Request{}, 2*time.Second); err != nil {
+		t.Errorf("client.CreateWithTimeout errored %+v\n", err)
+	}
+
+	server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		errorResponse(403, "Forbidden"),
+	})
+	defer server.Close()
+
+	client.BaseURL = server.URL
+	var start = time.Now()
+	_, err := client.CreateTunnelWithTimeout(&Request{}, 2*time.Second)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 403 {
+		t.Errorf("Invalid error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Failed after %s", elapsed)
+	}
+}
+
+// The status polling waits for the circuit to close
+func TestTunnelLoopCircuitOpen(t *testing.T) {
+	var count int32
+	var server = countingServer(&count, func() int { return 503 })
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Breaker:  &CircuitBreaker{Threshold: 2, Cooldown: 200 * time.Millisecond},
+	}
+	var tunnel = Tunnel{Client: &client, Id: "fakeid"}
+	go tunnel.serverStatusLoop(10 * time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Errorf("%d requests sent while the circuit is open", n)
+	}
+	// The probe
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&count); n != 3 {
+		t.Errorf("%d requests sent after the cooldown", n)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	var open = &ConnectionError{
+		Err: &CircuitOpenError{Until: time.Now().Add(time.Minute)},
+	}
+	if delay := retryDelay(open, time.Second); delay < 59*time.Second {
+		t.Errorf("Invalid delay %s", delay)
+	}
+	if delay := retryDelay(errors.New("oops"), time.Second); delay != time.Second {
+		t.Errorf("Invalid delay %s", delay)
+	}
+}
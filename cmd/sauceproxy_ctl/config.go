@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+//
+// Tunnel settings read from a `--config` file. The keys are the long names of
+// the create flags, and pointers let us tell a missing key from a zero value.
+//
+type tunnelConfig struct {
+	TunnelIdentifier *string        `yaml:"tunnel-identifier,omitempty" json:"tunnel-identifier,omitempty"`
+	TunnelDomains    []string       `yaml:"tunnel-domains,omitempty" json:"tunnel-domains,omitempty"`
+	DirectDomains    []string       `yaml:"direct-domains,omitempty" json:"direct-domains,omitempty"`
+	NoProxyCaching   *bool          `yaml:"no-proxy-caching,omitempty" json:"no-proxy-caching,omitempty"`
+	KgpPort          *int           `yaml:"kgp-port,omitempty" json:"kgp-port,omitempty"`
+	FastFailRegexps  []string       `yaml:"fast-fail-regexps,omitempty" json:"fast-fail-regexps,omitempty"`
+	SharedTunnel     *bool          `yaml:"shared-tunnel,omitempty" json:"shared-tunnel,omitempty"`
+	VmVersion        *string        `yaml:"vm-version,omitempty" json:"vm-version,omitempty"`
+	NoSslBumpDomains []string       `yaml:"no-ssl-bump-domains,omitempty" json:"no-ssl-bump-domains,omitempty"`
+	ExtraInfo        *extraInfo     `yaml:"extra-info,omitempty" json:"extra-info,omitempty"`
+	Timeout          *time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+//
+// The top-level keys of the file are the default settings, each entry of
+// `profiles` is applied on top of them when selected with --config-profile.
+//
+type configFile struct {
+	tunnelConfig `yaml:",inline"`
+	Profiles     map[string]tunnelConfig `yaml:"profiles,omitempty"`
+}
+
+//
+// ExtraInfo is sent as a string containing a JSON dict, but it is much more
+// readable as a mapping in a YAML file: accept both.
+//
+type extraInfo string
+
+func (e *extraInfo) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode((*string)(e))
+	}
+
+	var v interface{}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("extra-info: %s", err)
+	}
+	*e = extraInfo(b)
+
+	return nil
+}
+
+// Matches ${NAME} and ${NAME:-default}. A bare $NAME is left alone since
+// fast-fail-regexps are full of dollar signs.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+//
+// Replace the environment variable references in the scalar values of the
+// parsed document `node`, so that a value can't change the structure of the
+// document. Plain scalars are resolved again, `kgp-port: ${PORT}` is a
+// number. It is an error to reference an unset variable without a default
+// value.
+//
+func interpolateEnv(node *yaml.Node) error {
+	var missing []string
+	var expand = func(ref string) string {
+		var m = envReference.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(m[1]); ok {
+			return value
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		missing = append(missing, m[1])
+		return ref
+	}
+
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			if envReference.MatchString(node.Value) {
+				node.Value = envReference.ReplaceAllStringFunc(node.Value, expand)
+				if node.Style == 0 {
+					node.Tag = ""
+				}
+			}
+		case yaml.MappingNode:
+			// The keys are left alone
+			for i := 1; i < len(node.Content); i += 2 {
+				walk(node.Content[i])
+			}
+		default:
+			for _, child := range node.Content {
+				walk(child)
+			}
+		}
+	}
+	walk(node)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("undefined environment variables: %v", missing)
+	}
+
+	return nil
+}
+
+//
+// Load the config file at `path` (YAML or JSON), and return its settings
+// with `profile` applied on top of the defaults.
+//
+func loadConfig(path, profile string) (config tunnelConfig, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	var document yaml.Node
+	if err = yaml.Unmarshal(data, &document); err != nil {
+		return config, fmt.Errorf("%s: %s", path, err)
+	}
+	var file configFile
+	// An empty file has no document, treat it as an empty config
+	if document.Kind != 0 {
+		if err = interpolateEnv(&document); err != nil {
+			return config, fmt.Errorf("%s: %s", path, err)
+		}
+		// Node.Decode can't reject the unknown keys, decode the expanded
+		// document again instead
+		if data, err = yaml.Marshal(&document); err != nil {
+			return config, fmt.Errorf("%s: %s", path, err)
+		}
+		var decoder = yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&file); err != nil {
+			return config, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	config = file.tunnelConfig
+	if profile != "" {
+		p, ok := file.Profiles[profile]
+		if !ok {
+			var names []string
+			for name := range file.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return config, fmt.Errorf(
+				"%s: unknown profile %q, available profiles: %v",
+				path, profile, names)
+		}
+		config.merge(&p)
+	}
+
+	return
+}
+
+//
+// Override the settings of `c` with the ones present in `o`.
+//
+func (c *tunnelConfig) merge(o *tunnelConfig) {
+	if o.TunnelIdentifier != nil {
+		c.TunnelIdentifier = o.TunnelIdentifier
+	}
+	if o.TunnelDomains != nil {
+		c.TunnelDomains = o.TunnelDomains
+	}
+	if o.DirectDomains != nil {
+		c.DirectDomains = o.DirectDomains
+	}
+	if o.NoProxyCaching != nil {
+		c.NoProxyCaching = o.NoProxyCaching
+	}
+	if o.KgpPort != nil {
+		c.KgpPort = o.KgpPort
+	}
+	if o.FastFailRegexps != nil {
+		c.FastFailRegexps = o.FastFailRegexps
+	}
+	if o.SharedTunnel != nil {
+		c.SharedTunnel = o.SharedTunnel
+	}
+	if o.VmVersion != nil {
+		c.VmVersion = o.VmVersion
+	}
+	if o.NoSslBumpDomains != nil {
+		c.NoSslBumpDomains = o.NoSslBumpDomains
+	}
+	if o.ExtraInfo != nil {
+		c.ExtraInfo = o.ExtraInfo
+	}
+	if o.Timeout != nil {
+		c.Timeout = o.Timeout
+	}
+}
+
+//
+// Check the settings for mistakes we can catch before calling the REST API.
+//
+func (c *tunnelConfig) validate() error {
+	var errs []error
+
+	if c.KgpPort != nil && (*c.KgpPort <= 0 || *c.KgpPort > 65535) {
+		errs = append(errs, fmt.Errorf("kgp-port: invalid port %d", *c.KgpPort))
+	}
+	if c.ExtraInfo != nil && *c.ExtraInfo != "" {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(*c.ExtraInfo), &v); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"extra-info: not a JSON dict: %s", err))
+		}
+	}
+	if c.Timeout != nil && *c.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("timeout: negative duration %s", *c.Timeout))
+	}
+	for key, list := range map[string][]string{
+		"tunnel-domains":      c.TunnelDomains,
+		"direct-domains":      c.DirectDomains,
+		"fast-fail-regexps":   c.FastFailRegexps,
+		"no-ssl-bump-domains": c.NoSslBumpDomains,
+	} {
+		for _, s := range list {
+			if s == "" {
+				errs = append(errs, fmt.Errorf("%s: empty entry", key))
+				break
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+//
+// Return the settings of `o` as a config, so it can be displayed.
+//
+func configFromOptions(o *CreateOptions) tunnelConfig {
+	var e = extraInfo(o.ExtraInfo)
+
+	return tunnelConfig{
+		TunnelIdentifier: &o.TunnelIdentifier,
+		TunnelDomains:    o.TunnelDomains,
+		DirectDomains:    o.DirectDomains,
+		NoProxyCaching:   &o.NoProxyCaching,
+		KgpPort:          &o.KgpPort,
+		FastFailRegexps:  o.FastFailRegexps,
+		SharedTunnel:     &o.SharedTunnel,
+		VmVersion:        &o.VmVersion,
+		NoSslBumpDomains: o.NoSslBumpDomains,
+		ExtraInfo:        &e,
+		Timeout:          &o.Timeout,
+	}
+}
+
+//
+// Copy the config settings into `o`, except for the flags that were given on
+// the command line to `command`: those always win.
+//
+func (c *tunnelConfig) apply(command *flags.Command, o *CreateOptions) {
+	var fromFlag = func(long string) bool {
+		var option = command.FindOptionByLongName(long)
+		return option != nil && option.IsSet() && !option.IsSetDefault()
+	}
+
+	if c.TunnelIdentifier != nil && !fromFlag("tunnel-identifier") {
+		o.TunnelIdentifier = *c.TunnelIdentifier
+	}
+	if c.TunnelDomains != nil && !fromFlag("tunnel-domains") {
+		o.TunnelDomains = c.TunnelDomains
+	}
+	if c.DirectDomains != nil && !fromFlag("direct-domains") {
+		o.DirectDomains = c.DirectDomains
+	}
+	if c.NoProxyCaching != nil && !fromFlag("no-proxy-caching") {
+		o.NoProxyCaching = *c.NoProxyCaching
+	}
+	if c.KgpPort != nil && !fromFlag("kgp-port") {
+		o.KgpPort = *c.KgpPort
+	}
+	if c.FastFailRegexps != nil && !fromFlag("fast-fail-regexps") {
+		o.FastFailRegexps = c.FastFailRegexps
+	}
+	if c.SharedTunnel != nil && !fromFlag("shared-tunnel") {
+		o.SharedTunnel = *c.SharedTunnel
+	}
+	if c.VmVersion != nil && !fromFlag("vm-version") {
+		o.VmVersion = *c.VmVersion
+	}
+	if c.NoSslBumpDomains != nil && !fromFlag("no-ssl-bump-domains") {
+		o.NoSslBumpDomains = c.NoSslBumpDomains
+	}
+	if c.ExtraInfo != nil && !fromFlag("extra-info") {
+		o.ExtraInfo = string(*c.ExtraInfo)
+	}
+	if c.Timeout != nil && !fromFlag("timeout") {
+		o.Timeout = *c.Timeout
+	}
+}
+
+//
+// Print the timeout as a duration string, like in YAML, so that the JSON
+// output can be loaded as a config file again.
+//
+func (c tunnelConfig) MarshalJSON() ([]byte, error) {
+	type plain tunnelConfig
+	var document = struct {
+		plain
+		Timeout *string `json:"timeout,omitempty"`
+	}{plain: plain(c)}
+	if c.Timeout != nil {
+		var timeout = c.Timeout.String()
+		document.Timeout = &timeout
+	}
+
+	return json.Marshal(&document)
+}
+
+//
+// Return the settings as the rows of a table, in the order of the file, with
+// the lists joined by commas.
+//
+func (c *tunnelConfig) rows() ([][]string, error) {
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return nil, err
+	}
+
+	var rows = [][]string{{"KEY", "VALUE"}}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var value = node.Content[i+1]
+		var text = value.Value
+		if value.Kind == yaml.SequenceNode {
+			var items []string
+			for _, item := range value.Content {
+				items = append(items, item.Value)
+			}
+			text = strings.Join(items, ",")
+		}
+		rows = append(rows, []string{node.Content[i].Value, text})
+	}
+
+	return rows, nil
+}
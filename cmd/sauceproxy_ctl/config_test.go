@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// Write `content` to a config file in a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	var path = filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("os.WriteFile errored %+v\n", err)
+	}
+	return path
+}
+
+func stringPtr(s string) *string { return &s }
+func intPtr(i int) *int          { return &i }
+func boolPtr(b bool) *bool       { return &b }
+
+// Send the output of the commands in `format` to a buffer during the test
+func captureOutput(t *testing.T, format string) *bytes.Buffer {
+	var buffer bytes.Buffer
+	var saved = output
+	output = printer{format: format, out: &buffer, err: &buffer}
+	t.Cleanup(func() { output = saved })
+
+	return &buffer
+}
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("SAUCE_TEST_ID", "from-env")
+	t.Setenv("SAUCE_TEST_PORT", "8443")
+	t.Setenv("SAUCE_TEST_TRICKY", "a\"b: c\nshared-tunnel: true")
+
+	for _, test := range []struct {
+		content  string
+		expected tunnelConfig
+		err      string
+	}{
+		{
+			content:  "tunnel-identifier: ${SAUCE_TEST_ID}",
+			expected: tunnelConfig{TunnelIdentifier: stringPtr("from-env")},
+		},
+		{
+			content:  "tunnel-identifier: id-${SAUCE_TEST_UNSET:-default}",
+			expected: tunnelConfig{TunnelIdentifier: stringPtr("id-default")},
+		},
+		{
+			// Plain scalars are resolved after the expansion
+			content:  "kgp-port: ${SAUCE_TEST_PORT}",
+			expected: tunnelConfig{KgpPort: intPtr(8443)},
+		},
+		{
+			// The value stays a value
+			content: "tunnel-identifier: ${SAUCE_TEST_TRICKY}",
+			expected: tunnelConfig{
+				TunnelIdentifier: stringPtr("a\"b: c\nshared-tunnel: true"),
+			},
+		},
+		{
+			content: `tunnel-identifier: "${SAUCE_TEST_TRICKY}"`,
+			expected: tunnelConfig{
+				TunnelIdentifier: stringPtr("a\"b: c\nshared-tunnel: true"),
+			},
+		},
+		{
+			// Flow sequences need quotes, { starts a mapping there
+			content: "tunnel-domains: ['${SAUCE_TEST_ID}', b]",
+			expected: tunnelConfig{
+				TunnelDomains: []string{"from-env", "b"},
+			},
+		},
+		{
+			// Bare dollars are regexps
+			content:  "fast-fail-regexps: ['^$SAUCE_TEST_ID$']",
+			expected: tunnelConfig{FastFailRegexps: []string{"^$SAUCE_TEST_ID$"}},
+		},
+		{
+			content: "tunnel-identifier: ${SAUCE_TEST_UNSET}",
+			err:     "undefined environment variables: [SAUCE_TEST_UNSET]",
+		},
+	} {
+		config, err := loadConfig(writeConfig(t, test.content), "")
+		if test.err != "" {
+			if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+				t.Errorf("%q: invalid error %v", test.content, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: loadConfig errored %+v\n", test.content, err)
+		} else if !reflect.DeepEqual(config, test.expected) {
+			t.Errorf("%q: got %+v, expected %+v", test.content, config,
+				test.expected)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	var content = `
+tunnel-identifier: default
+kgp-port: 443
+tunnel-domains: [a.com]
+extra-info:
+  inject_js: true
+profiles:
+  ci:
+    tunnel-identifier: ci
+    shared-tunnel: true
+    timeout: 2m
+`
+	var minute = 2 * time.Minute
+	var extra = extraInfo(`{"inject_js":true}`)
+
+	for _, test := range []struct {
+		content  string
+		profile  string
+		expected tunnelConfig
+		err      string
+	}{
+		{
+			content: content,
+			expected: tunnelConfig{
+				TunnelIdentifier: stringPtr("default"),
+				KgpPort:          intPtr(443),
+				TunnelDomains:    []string{"a.com"},
+				ExtraInfo:        &extra,
+			},
+		},
+		{
+			content: content,
+			profile: "ci",
+			expected: tunnelConfig{
+				TunnelIdentifier: stringPtr("ci"),
+				KgpPort:          intPtr(443),
+				TunnelDomains:    []string{"a.com"},
+				ExtraInfo:        &extra,
+				SharedTunnel:     boolPtr(true),
+				Timeout:          &minute,
+			},
+		},
+		{
+			content:  `{"tunnel-identifier": "json"}`,
+			expected: tunnelConfig{TunnelIdentifier: stringPtr("json")},
+		},
+		{content: "", expected: tunnelConfig{}},
+		{content: "# nothing\n", expected: tunnelConfig{}},
+		{
+			content: content,
+			profile: "nightly",
+			err:     `unknown profile "nightly", available profiles: [ci]`,
+		},
+		{content: "tunnel-identifer: typo", err: "field tunnel-identifer not found"},
+		{content: "kgp-port: [1]", err: "cannot unmarshal"},
+		{content: "tunnel-identifier: 'unterminated", err: "yaml:"},
+	} {
+		config, err := loadConfig(writeConfig(t, test.content), test.profile)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: invalid error %v", test.content, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: loadConfig errored %+v\n", test.content, err)
+		} else if !reflect.DeepEqual(config, test.expected) {
+			t.Errorf("%q %s: got %+v, expected %+v", test.content,
+				test.profile, config, test.expected)
+		}
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+		t.Errorf("loadConfig of a missing file didn't error")
+	}
+}
+
+func TestTunnelConfigMerge(t *testing.T) {
+	for _, test := range []struct {
+		base, profile, expected tunnelConfig
+	}{
+		{
+			base:     tunnelConfig{TunnelIdentifier: stringPtr("a")},
+			profile:  tunnelConfig{},
+			expected: tunnelConfig{TunnelIdentifier: stringPtr("a")},
+		},
+		{
+			base:     tunnelConfig{TunnelIdentifier: stringPtr("a")},
+			profile:  tunnelConfig{TunnelIdentifier: stringPtr("b")},
+			expected: tunnelConfig{TunnelIdentifier: stringPtr("b")},
+		},
+		{
+			// An explicit zero value wins over the default
+			base:     tunnelConfig{SharedTunnel: boolPtr(true), KgpPort: intPtr(443)},
+			profile:  tunnelConfig{SharedTunnel: boolPtr(false)},
+			expected: tunnelConfig{SharedTunnel: boolPtr(false), KgpPort: intPtr(443)},
+		},
+		{
+			// Lists are replaced, not appended to
+			base:     tunnelConfig{DirectDomains: []string{"a", "b"}},
+			profile:  tunnelConfig{DirectDomains: []string{"c"}},
+			expected: tunnelConfig{DirectDomains: []string{"c"}},
+		},
+	} {
+		var config = test.base
+		config.merge(&test.profile)
+		if !reflect.DeepEqual(config, test.expected) {
+			t.Errorf("got %+v, expected %+v", config, test.expected)
+		}
+	}
+}
+
+func TestTunnelConfigApply(t *testing.T) {
+	var config = tunnelConfig{
+		TunnelIdentifier: stringPtr("config"),
+		KgpPort:          intPtr(8443),
+		SharedTunnel:     boolPtr(true),
+		DirectDomains:    []string{"config.com"},
+	}
+
+	for _, test := range []struct {
+		args     []string
+		expected CreateOptions
+	}{
+		{
+			args: nil,
+			expected: CreateOptions{
+				TunnelOptions: TunnelOptions{TunnelIdentifier: "config"},
+				KgpPort:       8443,
+				SharedTunnel:  true,
+				DirectDomains: []string{"config.com"},
+			},
+		},
+		{
+			// The flags win, the defaults of the flags don't
+			args: []string{"-i", "flag", "-D", "flag.com", "--vm-version", "v1"},
+			expected: CreateOptions{
+				TunnelOptions: TunnelOptions{TunnelIdentifier: "flag"},
+				KgpPort:       8443,
+				SharedTunnel:  true,
+				DirectDomains: []string{"flag.com"},
+				VmVersion:     "v1",
+			},
+		},
+		{
+			args: []string{"--kgp-port", "443"},
+			expected: CreateOptions{
+				TunnelOptions: TunnelOptions{TunnelIdentifier: "config"},
+				KgpPort:       443,
+				SharedTunnel:  true,
+				DirectDomains: []string{"config.com"},
+			},
+		},
+	} {
+		var options struct {
+			Create CreateOptions `command:"create"`
+		}
+		var parser = flags.NewParser(&options, flags.Default&^flags.PrintErrors)
+		if _, err := parser.ParseArgs(append([]string{"create"}, test.args...)); err != nil {
+			t.Fatalf("%v: ParseArgs errored %+v\n", test.args, err)
+		}
+		config.apply(parser.Active, &options.Create)
+		if !reflect.DeepEqual(options.Create, test.expected) {
+			t.Errorf("%v: got %+v, expected %+v", test.args, options.Create,
+				test.expected)
+		}
+	}
+}
+
+func TestTunnelConfigRows(t *testing.T) {
+	var extra = extraInfo(`{"a":1}`)
+	var config = tunnelConfig{
+		TunnelIdentifier: stringPtr("ci"),
+		TunnelDomains:    []string{"a.com", "b.com"},
+		KgpPort:          intPtr(443),
+		ExtraInfo:        &extra,
+	}
+	rows, err := config.rows()
+	if err != nil {
+		t.Fatalf("config.rows errored %+v\n", err)
+	}
+	var expected = [][]string{
+		{"KEY", "VALUE"},
+		{"tunnel-identifier", "ci"},
+		{"tunnel-domains", "a.com,b.com"},
+		{"kgp-port", "443"},
+		{"extra-info", `{"a":1}`},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Invalid rows %v", rows)
+	}
+}
+
+// The JSON output can be loaded as a config file
+func TestTunnelConfigJSON(t *testing.T) {
+	var timeout = 90 * time.Second
+	var config = tunnelConfig{
+		TunnelIdentifier: stringPtr("ci"),
+		Timeout:          &timeout,
+	}
+	data, err := json.Marshal(&config)
+	if err != nil {
+		t.Fatalf("json.Marshal errored %+v\n", err)
+	}
+	if expected := `{"tunnel-identifier":"ci","timeout":"1m30s"}`; string(data) != expected {
+		t.Errorf("Got %s, expected %s", data, expected)
+	}
+
+	loaded, err := loadConfig(writeConfig(t, string(data)), "")
+	if err != nil {
+		t.Fatalf("loadConfig errored %+v\n", err)
+	}
+	if !reflect.DeepEqual(loaded, config) {
+		t.Errorf("Loaded %+v, expected %+v", loaded, config)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/saucelabs/sauceproxy-rest"
+	"gopkg.in/yaml.v3"
+)
+
+//
+// Describe the sources of the credentials, for --help.
+//
+func credentialsHelp() string {
+	return fmt.Sprintf(`The username and the access key are looked up in these sources, in this order:
+  1. the --user and --api-key flags, and --api-key-file,
+  2. the SAUCE_USERNAME and SAUCE_ACCESS_KEY environment variables,
+  3. the --profile entry (or 'default') of %s, before the environment variables when --profile is given,
+  4. the $NETRC or ~/.netrc entry of the REST API host (see --region and --rest-url), login and password being the username and the access key.
+They are used as a pair: a source only completes the username or the access key of the previous ones if it doesn't give another username or access key.
+Prefer the files to --api-key, which shows up in ps and in the shell history.`,
+		credentialsPath())
+}
+
+//
+// Entry of the credentials file, keyed by profile name:
+//
+//	default:
+//	  username: john
+//	  access_key: 00000000-0000-0000-0000-000000000000
+//	staging:
+//	  username: john-staging
+//	  access_key: 00000000-0000-0000-0000-000000000000
+//	  rest_url: https://staging.example.com/rest/v1
+//
+type credentialsProfile struct {
+	Username  string `yaml:"username"`
+	AccessKey string `yaml:"access_key"`
+	RestUrl   string `yaml:"rest_url"`
+}
+
+//
+// Return the path of the credentials file, in the user configuration
+// directory.
+//
+func credentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "sauceproxy", "credentials")
+}
+
+//
+// Read the profile `name` of the credentials file at `path`. A missing file
+// or profile isn't an error unless `required` is set.
+//
+func readCredentialsProfile(path, name string, required bool) (
+	profile credentialsProfile, err error,
+) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return profile, nil
+	} else if err != nil {
+		return profile, err
+	}
+
+	var profiles map[string]credentialsProfile
+	if err = yaml.Unmarshal(data, &profiles); err != nil {
+		return profile, fmt.Errorf("%s: %s", path, err)
+	}
+
+	profile, ok := profiles[name]
+	if !ok && required {
+		return profile, fmt.Errorf("%s: no profile %q", path, name)
+	}
+
+	return profile, nil
+}
+
+//
+// Return the login and password of `host` in the netrc file at `path`, or of
+// its 'default' entry. A missing file isn't an error.
+//
+func readNetrc(path, host string) (login, password string, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	var scanner = bufio.NewScanner(file)
+	scanner.Split(bufio.ScanWords)
+
+	// Entries are "machine <host>" or "default", followed by key/value pairs
+	var found, inMacro = false, false
+	var current string
+	for scanner.Scan() {
+		var token = scanner.Text()
+		if inMacro {
+			// Macro definitions can't be told apart with ScanWords, they
+			// are rare enough to skip everything after them.
+			continue
+		}
+
+		switch token {
+		case "machine", "default":
+			if found {
+				return login, password, nil
+			}
+			current = ""
+			if token == "default" {
+				current = host
+			} else if scanner.Scan() {
+				current = scanner.Text()
+			}
+			found = current == host
+		case "login", "password", "account":
+			if !scanner.Scan() {
+				break
+			}
+			if !found {
+				continue
+			}
+			if token == "login" {
+				login = scanner.Text()
+			} else if token == "password" {
+				password = scanner.Text()
+			}
+		case "macdef":
+			inMacro = true
+		}
+	}
+	if !found {
+		login, password = "", ""
+	}
+
+	return login, password, scanner.Err()
+}
+
+//
+// Return the path of the netrc file, $NETRC or ~/.netrc.
+//
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".netrc")
+}
+
+//
+// Return the access key in the file at `path`, --api-key-file.
+//
+func readKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var key = strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+
+	return key, nil
+}
+
+//
+// Return a CredentialProvider.Fetch function reading the access key of
+// `username` from the --api-key-file at `path`, so that a key rotated in the
+// file is picked up when the REST API rejects the previous one.
+//
+func keyFileCredentials(username, path string) func() (rest.Credentials, error) {
+	return func() (rest.Credentials, error) {
+		key, err := readKeyFile(path)
+		return rest.Credentials{Username: username, AccessKey: key}, err
+	}
+}
+
+//
+// Fill in the username, access key and REST URL of `options` from the
+// sources listed in credentialsHelp. `parser` tells the flags given on the
+// command line from the environment variables.
+//
+func resolveCredentials(parser *flags.Parser, options *CommonOptions) error {
+	var fromCommandLine = func(name string) bool {
+		var option = parser.FindOptionByLongName(name)
+		return option != nil && option.IsSet() && !option.IsSetDefault()
+	}
+	var set = func(value *string, candidate string) {
+		if *value == "" {
+			*value = candidate
+		}
+	}
+
+	var user, key, restUrl string
+	// Complete the credentials with the ones of a source, unless they are
+	// for another account
+	var add = func(sourceUser, sourceKey string) {
+		if (user != "" && sourceUser != "" && sourceUser != user) ||
+			(key != "" && sourceKey != "" && sourceKey != key) {
+			return
+		}
+		set(&user, sourceUser)
+		set(&key, sourceKey)
+	}
+
+	if fromCommandLine("user") {
+		user = options.User
+	}
+	if fromCommandLine("api-key") {
+		key = options.ApiKey
+	}
+	if fromCommandLine("rest-url") {
+		if fromCommandLine("region") {
+			return fmt.Errorf("--region and --rest-url are exclusive")
+		}
+		restUrl = options.RestUrl
+		// The URL given wins over SAUCE_REGION
+		options.Region = ""
+	}
+	if options.Region != "" {
+		region, err := rest.ParseRegion(options.Region)
+		if err != nil {
+			return err
+		}
+		options.Region = string(region)
+		set(&restUrl, region.RestURL())
+	}
+
+	if options.ApiKeyFile != "" {
+		if fromCommandLine("api-key") {
+			return fmt.Errorf("--api-key and --api-key-file are exclusive")
+		}
+		var err error
+		if key, err = readKeyFile(options.ApiKeyFile); err != nil {
+			return err
+		}
+	}
+
+	var name = options.Profile
+	if name == "" {
+		name = "default"
+	}
+	profile, err := readCredentialsProfile(
+		credentialsPath(), name, options.Profile != "")
+	if err != nil {
+		return err
+	}
+	// A profile asked for wins over the environment, CI machines often
+	// export SAUCE_USERNAME and SAUCE_ACCESS_KEY
+	var explicitProfile = fromCommandLine("profile")
+	if explicitProfile {
+		add(profile.Username, profile.AccessKey)
+		set(&restUrl, profile.RestUrl)
+	}
+
+	// Environment variables. A key given by --api-key-file only overrides
+	// SAUCE_ACCESS_KEY, the username still comes from SAUCE_USERNAME.
+	var envUser, envKey string
+	if !fromCommandLine("user") {
+		envUser = options.User
+	}
+	if !fromCommandLine("api-key") && options.ApiKeyFile == "" {
+		envKey = options.ApiKey
+	}
+	add(envUser, envKey)
+
+	if !explicitProfile {
+		add(profile.Username, profile.AccessKey)
+		set(&restUrl, profile.RestUrl)
+	}
+	set(&restUrl, options.RestUrl)
+
+	if user == "" || key == "" {
+		u, err := url.Parse(restUrl)
+		if err != nil {
+			return fmt.Errorf("invalid REST URL %s: %s", restUrl, err)
+		}
+		login, password, err := readNetrc(netrcPath(), u.Hostname())
+		if err != nil {
+			return err
+		}
+		add(login, password)
+	}
+
+	options.User, options.ApiKey, options.RestUrl = user, key, restUrl
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+func TestReadNetrc(t *testing.T) {
+	for _, test := range []struct {
+		content         string
+		login, password string
+	}{
+		{
+			content:  "machine saucelabs.com login john password key",
+			login:    "john",
+			password: "key",
+		},
+		{
+			// Entries of other hosts are skipped
+			content: `machine example.com login other password other-key
+machine saucelabs.com
+  login john
+  password key
+machine api.example.com login last password last-key`,
+			login:    "john",
+			password: "key",
+		},
+		{
+			content:  "machine example.com login other password other-key\ndefault login john password key",
+			login:    "john",
+			password: "key",
+		},
+		{
+			// The first matching entry wins over default
+			content:  "machine saucelabs.com login john password key\ndefault login other password other-key",
+			login:    "john",
+			password: "key",
+		},
+		{
+			content:  "machine saucelabs.com account acme login john password key",
+			login:    "john",
+			password: "key",
+		},
+		{
+			content: "machine example.com login other password other-key",
+		},
+		{
+			// Nothing after a macro definition is read
+			content: "macdef init\ncd /\n\nmachine saucelabs.com login john password key",
+		},
+		{content: ""},
+	} {
+		var path = filepath.Join(t.TempDir(), "netrc")
+		if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatalf("os.WriteFile errored %+v\n", err)
+		}
+		login, password, err := readNetrc(path, "saucelabs.com")
+		if err != nil {
+			t.Errorf("%q: readNetrc errored %+v\n", test.content, err)
+		} else if login != test.login || password != test.password {
+			t.Errorf("%q: got %q %q, expected %q %q", test.content,
+				login, password, test.login, test.password)
+		}
+	}
+
+	login, password, err := readNetrc(
+		filepath.Join(t.TempDir(), "missing"), "saucelabs.com")
+	if login != "" || password != "" || err != nil {
+		t.Errorf("Missing netrc file returned %q %q %v", login, password, err)
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	var config = t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("HOME", t.TempDir())
+	os.Mkdir(filepath.Join(config, "sauceproxy"), 0700)
+	os.WriteFile(filepath.Join(config, "sauceproxy", "credentials"), []byte(`
+default:
+  username: profile-user
+  access_key: profile-key
+staging:
+  username: staging-user
+  access_key: staging-key
+`), 0600)
+	var netrc = filepath.Join(t.TempDir(), "netrc")
+	os.WriteFile(netrc,
+		[]byte("machine saucelabs.com login netrc-user password netrc-key"), 0600)
+	t.Setenv("NETRC", netrc)
+
+	var keyFile = filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte("file-key\n"), 0600)
+
+	for _, test := range []struct {
+		args      []string
+		env       map[string]string
+		user, key string
+	}{
+		{
+			args: nil,
+			user: "profile-user",
+			key:  "profile-key",
+		},
+		{
+			args: []string{"-u", "flag-user", "-k", "flag-key"},
+			env:  map[string]string{"SAUCE_USERNAME": "env-user", "SAUCE_ACCESS_KEY": "env-key"},
+			user: "flag-user",
+			key:  "flag-key",
+		},
+		{
+			env:  map[string]string{"SAUCE_USERNAME": "env-user", "SAUCE_ACCESS_KEY": "env-key"},
+			user: "env-user",
+			key:  "env-key",
+		},
+		{
+			// The key of the profile isn't the one of env-user
+			env:  map[string]string{"SAUCE_USERNAME": "env-user"},
+			user: "env-user",
+		},
+		{
+			// Same for the username
+			args: []string{"-k", "flag-key"},
+			key:  "flag-key",
+		},
+		{
+			args: []string{"-u", "netrc-user"},
+			user: "netrc-user",
+			key:  "netrc-key",
+		},
+		{
+			args: []string{"-u", "profile-user"},
+			env:  map[string]string{"SAUCE_ACCESS_KEY": "env-key"},
+			user: "profile-user",
+			key:  "env-key",
+		},
+		{
+			// A profile given on the command line wins over the environment
+			args: []string{"--profile", "staging"},
+			env:  map[string]string{"SAUCE_USERNAME": "env-user", "SAUCE_ACCESS_KEY": "env-key"},
+			user: "staging-user",
+			key:  "staging-key",
+		},
+		{
+			// The key of the file only overrides SAUCE_ACCESS_KEY
+			args: []string{"--api-key-file", keyFile},
+			env:  map[string]string{"SAUCE_USERNAME": "env-user", "SAUCE_ACCESS_KEY": "env-key"},
+			user: "env-user",
+			key:  "file-key",
+		},
+	} {
+		for _, name := range []string{"SAUCE_USERNAME", "SAUCE_ACCESS_KEY"} {
+			t.Setenv(name, test.env[name])
+			if test.env[name] == "" {
+				os.Unsetenv(name)
+			}
+		}
+
+		var options struct {
+			CommonOptions
+		}
+		var parser = flags.NewParser(&options, flags.Default&^flags.PrintErrors)
+		if _, err := parser.ParseArgs(test.args); err != nil {
+			t.Fatalf("%v: ParseArgs errored %+v\n", test.args, err)
+		}
+		if err := resolveCredentials(parser, &options.CommonOptions); err != nil {
+			t.Errorf("%v %v: resolveCredentials errored %+v\n",
+				test.args, test.env, err)
+		} else if options.User != test.user || options.ApiKey != test.key {
+			t.Errorf("%v %v: got %q %q, expected %q %q", test.args, test.env,
+				options.User, options.ApiKey, test.user, test.key)
+		}
+	}
+}
+
+func TestKeyFileCredentials(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "key")
+	var fetch = keyFileCredentials("john", path)
+
+	for _, key := range []string{"old-key", "new-key"} {
+		os.WriteFile(path, []byte(key+"\n"), 0600)
+		credentials, err := fetch()
+		if err != nil {
+			t.Errorf("%s: fetch errored %+v\n", key, err)
+		} else if credentials != (rest.Credentials{Username: "john", AccessKey: key}) {
+			t.Errorf("%s: got %+v", key, credentials)
+		}
+	}
+
+	os.WriteFile(path, []byte("\n"), 0600)
+	if _, err := fetch(); err == nil {
+		t.Errorf("fetch of an empty file didn't error")
+	}
+}
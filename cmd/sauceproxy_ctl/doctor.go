@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+type DoctorOptions struct {
+	Timeout time.Duration `long:"timeout" value-name:"<duration>" description:"Timeout of each check." default:"10s"`
+}
+
+//
+// Run the connectivity checks, printing them as they go in text mode, and
+// exit with an error if one failed.
+//
+func doctorCommand(
+	client *rest.Client, transport *http.Transport, options *DoctorOptions,
+) {
+	var diagnostics = rest.Diagnostics{
+		Client:    client,
+		Transport: transport,
+		Timeout:   options.Timeout,
+	}
+	var report = func(check rest.Check) {
+		if output.format == "text" {
+			fmt.Fprintf(output.out, "%-4s  %-11s  %7s  %s\n",
+				strings.ToUpper(check.Status), check.Name,
+				check.Duration.Round(time.Millisecond), check.Detail)
+		}
+	}
+	var checks = diagnostics.Run(context.Background(), report)
+
+	type jsonCheck struct {
+		rest.Check
+		DurationMs int64 `json:"duration_ms"`
+	}
+	var doc []jsonCheck
+	var rows = [][]string{{"CHECK", "STATUS", "DURATION", "DETAIL"}}
+	var failed = false
+	for _, check := range checks {
+		doc = append(doc, jsonCheck{check, check.Duration.Milliseconds()})
+		rows = append(rows, []string{check.Name, check.Status,
+			check.Duration.Round(time.Millisecond).String(), check.Detail})
+		failed = failed || check.Status == rest.CheckFail
+	}
+	if output.format != "text" {
+		output.print(doc, rows, "")
+	}
+
+	if failed {
+		os.Exit(exitError)
+	}
+}
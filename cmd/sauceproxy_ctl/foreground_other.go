@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+//
+// There are no process groups to look at outside of Unix, always forward
+// (os.Process.Signal only supports Kill there anyway).
+//
+func inForeground() bool {
+	return false
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+//
+// Return whether we are in the foreground process group of our controlling
+// terminal, the one getting the SIGINT of a Ctrl-C. Signals can't tell who
+// sent them: a SIGINT received outside of the foreground, or without a
+// terminal, can't come from a Ctrl-C.
+//
+func inForeground() bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	pgrp, err := unix.IoctlGetInt(int(tty.Fd()), unix.TIOCGPGRP)
+	if err != nil {
+		return false
+	}
+	return pgrp == unix.Getpgrp()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+type KgpHostOptions struct {
+	Arg struct {
+		Id string `description:"Tunnel ID (not tunnel identifier)"`
+	} `positional-args:"yes" required:"yes"`
+	Probe   bool          `long:"probe" description:"Also connect to the KGP server, by DNS name and by IP address, to check it can be reached."`
+	Tls     bool          `long:"tls" description:"With --probe, also do a TLS handshake with the KGP server."`
+	KgpPort int           `long:"kgp-port" value-name:"<port>" description:"Port of the KGP server." default:"443"`
+	Timeout time.Duration `long:"timeout" value-name:"<duration>" description:"Timeout of the probes." default:"10s"`
+}
+
+type kgpProbe struct {
+	Target      string   `json:"target"`
+	Address     string   `json:"address"`
+	Resolved    []string `json:"resolved,omitempty"`
+	Ok          bool     `json:"ok"`
+	LookupMs    int64    `json:"lookup_ms"`
+	ConnectMs   int64    `json:"connect_ms"`
+	HandshakeMs int64    `json:"handshake_ms,omitempty"`
+	Certificate string   `json:"certificate,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+//
+// Print the KGP host of a tunnel, and with --probe whether it can be reached.
+// Exits with an error if none of its addresses can be.
+//
+func kgpHostCommand(
+	client *rest.Client, transport *http.Transport, options *KgpHostOptions,
+) {
+	var id = options.Arg.Id
+	host, hostIp, err := client.KgpHost(id)
+	if err != nil {
+		output.fatal("Unable to query KGP host:", err)
+	}
+
+	var doc = struct {
+		Id     string     `json:"id"`
+		Host   string     `json:"host"`
+		Ip     string     `json:"ip_address"`
+		Probes []kgpProbe `json:"probes,omitempty"`
+	}{Id: id, Host: host, Ip: hostIp}
+	var rows = [][]string{{"ID", "HOST", "IP"}, {id, host, hostIp}}
+	var text strings.Builder
+	fmt.Fprintf(&text, "KGP server hostname: %s, ip address: %s\n", host, hostIp)
+
+	var reachable = true
+	if options.Probe {
+		var tunnel = client.Tunnel(id)
+		tunnel.KGPPort = options.KgpPort
+		// Probe the KGP host we just got instead of asking again
+		tunnel.Host, tunnel.Ip = host, hostIp
+		ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+		defer cancel()
+
+		var probes []rest.KGPProbe
+		if options.Tls {
+			// The pins are for the REST API only
+			var config = transport.TLSClientConfig.Clone()
+			config.VerifyConnection = nil
+			probes, err = tunnel.ProbeKGPTLS(ctx, config)
+		} else {
+			probes, err = tunnel.ProbeKGP(ctx)
+		}
+		if err != nil {
+			output.fatal("Unable to probe KGP host:", err)
+		}
+
+		reachable = false
+		rows = [][]string{{"TARGET", "ADDRESS", "OK", "CONNECT"}}
+		if options.Tls {
+			rows[0] = append(rows[0], "TLS")
+		}
+		rows[0] = append(rows[0], "ERROR")
+		for _, probe := range probes {
+			reachable = reachable || probe.OK()
+			var p = kgpProbe{
+				Target:      probe.Target,
+				Address:     probe.Address,
+				Resolved:    probe.Resolved,
+				Ok:          probe.OK(),
+				LookupMs:    probe.Lookup.Milliseconds(),
+				ConnectMs:   probe.Connect.Milliseconds(),
+				HandshakeMs: probe.Handshake.Milliseconds(),
+				Certificate: probe.Certificate,
+			}
+			var result = fmt.Sprintf("OK, connected in %s",
+				probe.Connect.Round(time.Millisecond))
+			if options.Tls && probe.OK() {
+				result += fmt.Sprintf(", TLS handshake in %s (%s)",
+					probe.Handshake.Round(time.Millisecond), probe.Certificate)
+			}
+			if probe.Err != nil {
+				p.Error = probe.Err.Error()
+				result = "FAILED: " + p.Error
+			}
+			doc.Probes = append(doc.Probes, p)
+			var row = []string{
+				p.Target, p.Address, fmt.Sprint(p.Ok),
+				probe.Connect.Round(time.Millisecond).String(),
+			}
+			if options.Tls {
+				row = append(row, probe.Handshake.Round(time.Millisecond).String())
+			}
+			rows = append(rows, append(row, p.Error))
+			fmt.Fprintf(&text, "%s %s: %s\n", p.Target, p.Address, result)
+		}
+	}
+
+	output.print(doc, rows, text.String())
+	if !reachable {
+		os.Exit(exitConnection)
+	}
+}
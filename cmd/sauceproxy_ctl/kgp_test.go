@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+func TestKgpHostCommandProbe(t *testing.T) {
+	var kgp, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen errored %+v\n", err)
+	}
+	defer kgp.Close()
+	go func() {
+		for {
+			conn, err := kgp.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	var queries int32
+	var server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&queries, 1)
+			fmt.Fprint(w, `{"status": "running", "host": "", "ip_address": "127.0.0.1"}`)
+		}))
+	defer server.Close()
+	var client = &rest.Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	var printed = captureOutput(t, "table")
+	var options = KgpHostOptions{
+		Probe:   true,
+		KgpPort: kgp.Addr().(*net.TCPAddr).Port,
+		Timeout: time.Second,
+	}
+	options.Arg.Id = "fakeid"
+	kgpHostCommand(client, &http.Transport{}, &options)
+
+	// The status isn't queried again for the probes
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Errorf("Status queried %d times, expected once", n)
+	}
+	var lines = strings.Split(printed.String(), "\n")
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") !=
+		"TARGET ADDRESS OK CONNECT ERROR" {
+		t.Errorf("Invalid header without --tls: %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 4 ||
+		fields[0] != "ip" || fields[2] != "true" {
+		t.Errorf("Invalid probe: %q", lines[1])
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+//
+// Return the format of the logs: --log-format, or JSON with --output json so
+// that stderr only holds JSON documents.
+//
+func logFormat(o *CommonOptions) string {
+	if o.LogFormat == "" && o.Output == "json" {
+		return "json"
+	}
+	return o.LogFormat
+}
+
+//
+// Return a logger writing to `w` in `format`, "text" or "json". The level
+// defaults to "debug" with --verbose, and to "warn" otherwise.
+//
+func newLogger(w io.Writer, format, level string, verbose bool) *slog.Logger {
+	if level == "" {
+		level = "warn"
+		if verbose {
+			level = "debug"
+		}
+	}
+	var options = slog.HandlerOptions{Level: logLevels[level]}
+
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, &options))
+	}
+	return slog.New(slog.NewTextHandler(w, &options))
+}
+
+//
+// Log the details of a request to the REST API, for --verbose.
+//
+func logDebugEntry(entry *rest.DebugEntry) {
+	var attrs = []interface{}{
+		"method", entry.Method,
+		"url", entry.URL,
+		"status", entry.StatusCode,
+		"latency", entry.Latency,
+		headerAttr("request_headers", entry.RequestHeader),
+		"request_body", entry.RequestBody,
+		headerAttr("response_headers", entry.ResponseHeader),
+		"response_body", entry.ResponseBody,
+	}
+	if entry.Err != nil {
+		attrs = append(attrs, "error", entry.Err)
+	}
+
+	logger.Debug("REST request details", attrs...)
+}
+
+//
+// Return the headers as a group, one attribute per header.
+//
+func headerAttr(name string, header http.Header) slog.Attr {
+	var keys []string
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var attrs []interface{}
+	for _, key := range keys {
+		attrs = append(attrs, key, strings.Join(header[key], ", "))
+	}
+
+	return slog.Group(name, attrs...)
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestLogFormat(t *testing.T) {
+	for _, test := range []struct {
+		logFormat, output string
+		expected          string
+	}{
+		{"", "text", ""},
+		{"", "table", ""},
+		{"", "json", "json"},
+		{"text", "json", "text"},
+		{"json", "text", "json"},
+	} {
+		var options = CommonOptions{LogFormat: test.logFormat, Output: test.output}
+		if format := logFormat(&options); format != test.expected {
+			t.Errorf("--log-format %q --output %s: got %q, expected %q",
+				test.logFormat, test.output, format, test.expected)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+type MetricsOptions struct {
+	MetricsListen string `long:"metrics-listen" value-name:"<address>" description:"Serve Prometheus metrics on /metrics at this address (example: :9090)."`
+}
+
+//
+// Serve the metrics of the client on `address` in the background, exits if
+// it can't listen on `address`.
+//
+func serveMetrics(address string) *rest.PrometheusMetrics {
+	var metrics = &rest.PrometheusMetrics{}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		output.fatal("Unable to serve metrics:", err)
+	}
+
+	var mux = http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logger.Error("Unable to serve metrics", "error", err)
+		}
+	}()
+	logger.Info("Serving metrics", "address", listener.Addr().String())
+
+	return metrics
+}
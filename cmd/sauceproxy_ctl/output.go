@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+//
+// Exit codes, one per class of error. Scripts rely on them: never renumber.
+//
+const (
+	exitError      = 1 // Anything not covered below
+	exitUsage      = 2 // Bad command line or config file
+	exitConnection = 3 // The REST API couldn't be reached
+	exitAuth       = 4 // The REST API rejected the credentials
+	exitNotFound   = 5 // No such tunnel
+	exitAPI        = 6 // Any other error returned by the REST API
+	exitTimeout    = 7 // The tunnel didn't come up in time
+	exitTunnelDown = 8 // The tunnel went down on its own during `up` or `run`
+)
+
+var errorClasses = map[int]string{
+	exitError:      "error",
+	exitUsage:      "usage",
+	exitConnection: "connection",
+	exitAuth:       "authentication",
+	exitNotFound:   "not_found",
+	exitAPI:        "api",
+	exitTimeout:    "timeout",
+	exitTunnelDown: "tunnel_down",
+}
+
+//
+// Return the exit code matching the class of `err`.
+//
+func exitCode(err error) int {
+	var httpErr *rest.HTTPError
+	var connErr *rest.ConnectionError
+	var timeoutErr *rest.TimeoutError
+
+	switch {
+	case errors.As(err, &httpErr):
+		switch httpErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return exitAuth
+		case http.StatusNotFound:
+			return exitNotFound
+		}
+		return exitAPI
+	case errors.As(err, &connErr):
+		return exitConnection
+	case errors.As(err, &timeoutErr):
+		return exitTimeout
+	}
+
+	return exitError
+}
+
+// Exits the process, replaced by the tests
+var exit = os.Exit
+
+//
+// Writes the results of the commands, and their errors, in the format chosen
+// with --output.
+//
+type printer struct {
+	format string
+	out    io.Writer
+	err    io.Writer
+}
+
+//
+// Print the result of a command: `doc` for --output json, `rows` for
+// --output table (the first row is the header), and `text` otherwise.
+//
+func (p *printer) print(doc interface{}, rows [][]string, text string) {
+	switch p.format {
+	case "json":
+		var encoder = json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(doc)
+	case "table":
+		var w = tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	default:
+		io.WriteString(p.out, text)
+	}
+}
+
+//
+// Log an informative message, JSON output keeps stderr for errors only.
+//
+func (p *printer) info(v ...interface{}) {
+	if p.format != "json" {
+		fmt.Fprintln(p.err, v...)
+	}
+}
+
+//
+// Report `err` and exit with the code of its class.
+//
+func (p *printer) fatal(message string, err error) {
+	p.exit(exitCode(err), message, err)
+}
+
+func (p *printer) exit(code int, message string, err error) {
+	if p.format == "json" {
+		var doc = struct {
+			Error struct {
+				Class    string `json:"class"`
+				Message  string `json:"message"`
+				ExitCode int    `json:"exit_code"`
+			} `json:"error"`
+		}{}
+		doc.Error.Class = errorClasses[code]
+		doc.Error.Message = message
+		if err != nil {
+			doc.Error.Message = fmt.Sprintf("%s %s", message, err)
+		}
+		doc.Error.ExitCode = code
+		json.NewEncoder(p.err).Encode(&doc)
+	} else if err != nil {
+		fmt.Fprintln(p.err, message, err)
+	} else {
+		fmt.Fprintln(p.err, message)
+	}
+
+	exit(code)
+}
+
+var tunnelHeader = []string{
+	"ID", "IDENTIFIER", "OWNER", "STATUS", "HOST", "IP", "DOMAINS", "CREATED",
+}
+
+func tunnelRow(t *rest.TunnelInfo) []string {
+	var created = ""
+	if t.CreationTime != 0 {
+		created = time.Unix(t.CreationTime, 0).UTC().Format(time.RFC3339)
+	}
+
+	return []string{
+		t.Id,
+		t.TunnelIdentifier,
+		t.Owner,
+		t.State(),
+		t.Host,
+		t.Ip,
+		strings.Join(t.DomainNames, ","),
+		created,
+	}
+}
+
+//
+// Print a list of tunnels, one ID per line in text mode.
+//
+func (p *printer) printTunnels(tunnels []rest.TunnelInfo) {
+	var rows = [][]string{tunnelHeader}
+	var text strings.Builder
+
+	for i := range tunnels {
+		rows = append(rows, tunnelRow(&tunnels[i]))
+		fmt.Fprintln(&text, tunnels[i].Id)
+	}
+	if tunnels == nil {
+		tunnels = []rest.TunnelInfo{}
+	}
+
+	p.print(tunnels, rows, text.String())
+}
+
+//
+// Print a single tunnel, `text` is the output in text mode.
+//
+func (p *printer) printTunnel(tunnel *rest.TunnelInfo, text string) {
+	p.print(tunnel, [][]string{tunnelHeader, tunnelRow(tunnel)}, text)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+// Exit code passed to exit, as a panic
+type exited int
+
+//
+// Run `f`, and return the exit code it exited with, -1 if it returned.
+//
+func catchExit(f func()) (code int) {
+	var saved = exit
+	exit = func(code int) { panic(exited(code)) }
+	defer func() { exit = saved }()
+
+	defer func() {
+		if r := recover(); r != nil {
+			exitCode, ok := r.(exited)
+			if !ok {
+				panic(r)
+			}
+			code = int(exitCode)
+		}
+	}()
+	code = -1
+	f()
+	return
+}
+
+// Return the error of a request answered with `status`
+func httpError(status int) error {
+	return &rest.HTTPError{
+		URL:        "https://saucelabs.com/rest/v1/john/tunnels",
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"unauthorized", httpError(401), exitAuth},
+		{"forbidden", httpError(403), exitAuth},
+		{"not found", httpError(404), exitNotFound},
+		{"server error", httpError(500), exitAPI},
+		{"bad request", httpError(400), exitAPI},
+		{"connection", &rest.ConnectionError{URL: "u", Err: errors.New("refused")}, exitConnection},
+		{"breaker open", &rest.ConnectionError{URL: "u", Err: &rest.CircuitOpenError{Until: time.Now()}}, exitConnection},
+		{"timeout", &rest.TimeoutError{Id: "fakeid", Timeout: time.Minute}, exitTimeout},
+		{"wrapped", fmt.Errorf("shutdown: %w", httpError(404)), exitNotFound},
+		{"other", errors.New("oops"), exitError},
+		{"nil", nil, exitError},
+	} {
+		if code := exitCode(test.err); code != test.expected {
+			t.Errorf("%s: got %d, expected %d", test.name, code, test.expected)
+		}
+	}
+}
+
+func TestPrinterExit(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		code    int
+		message string
+		err     error
+		class   string
+		full    string
+	}{
+		{
+			name:    "usage",
+			code:    exitUsage,
+			message: "Extra arguments: [a]",
+			class:   "usage",
+			full:    "Extra arguments: [a]",
+		},
+		{
+			name:    "not found",
+			code:    exitNotFound,
+			message: "Unable to query tunnel status:",
+			err:     errors.New("no such tunnel"),
+			class:   "not_found",
+			full:    "Unable to query tunnel status: no such tunnel",
+		},
+		{
+			name:    "tunnel down",
+			code:    exitTunnelDown,
+			message: "Tunnel went down",
+			class:   "tunnel_down",
+			full:    "Tunnel went down",
+		},
+	} {
+		var printed = captureOutput(t, "json")
+		var code = catchExit(func() {
+			output.exit(test.code, test.message, test.err)
+		})
+		if code != test.code {
+			t.Errorf("%s: exited with %d, expected %d", test.name, code, test.code)
+		}
+
+		var doc map[string]map[string]interface{}
+		if err := json.Unmarshal(printed.Bytes(), &doc); err != nil {
+			t.Fatalf("%s: invalid JSON %q: %s", test.name, printed, err)
+		}
+		var expected = map[string]interface{}{
+			"class":     test.class,
+			"message":   test.full,
+			"exit_code": float64(test.code),
+		}
+		if len(doc) != 1 || fmt.Sprint(doc["error"]) != fmt.Sprint(expected) {
+			t.Errorf("%s: got %v, expected an error object %v",
+				test.name, doc, expected)
+		}
+	}
+
+	// Text mode prints the bare message
+	var printed = captureOutput(t, "text")
+	var code = catchExit(func() {
+		output.fatal("Unable to list tunnels:", httpError(401))
+	})
+	if code != exitAuth {
+		t.Errorf("Exited with %d, expected %d", code, exitAuth)
+	}
+	if expected := "Unable to list tunnels: " + httpError(401).Error() + "\n"; printed.String() != expected {
+		t.Errorf("Printed %q, expected %q", printed, expected)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+type ReapOptions struct {
+	MetricsOptions
+
+	MaxIdle     time.Duration `long:"max-idle" value-name:"<duration>" description:"Shut down the tunnels without a KGP connection for this long (example: 1h)."`
+	MaxAge      time.Duration `long:"max-age" value-name:"<duration>" description:"Shut down the tunnels created more than this long ago (example: 24h)."`
+	Inventory   string        `long:"inventory" value-name:"<file>" description:"File listing the hostnames allowed to run tunnels, one per line. Tunnels started from other hosts are shut down. The file is read again before every pass."`
+	AuditLog    string        `long:"audit-log" value-name:"<file>" description:"Append a JSON line for every tunnel shut down to this file."`
+	Interval    time.Duration `long:"interval" value-name:"<duration>" description:"Time between two passes." default:"5m"`
+	Once        bool          `long:"once" description:"Do a single pass and exit."`
+	DryRun      bool          `long:"dry-run" description:"Only report the tunnels that would be shut down."`
+	WaitForJobs bool          `long:"wait-for-jobs" description:"Let the jobs running on the tunnels finish before they go down."`
+}
+
+//
+// Return the hostnames listed in `path`, ignoring blank lines and comments.
+//
+func readInventory(path string) (hosts []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			hosts = append(hosts, line)
+		}
+	}
+
+	return hosts, scanner.Err()
+}
+
+func reapCommand(client *rest.Client, o *ReapOptions) {
+	if o.MaxIdle == 0 && o.MaxAge == 0 && o.Inventory == "" {
+		output.exit(exitUsage,
+			"Nothing to reap, use --max-idle, --max-age or --inventory", nil)
+	}
+
+	var audit *json.Encoder
+	if o.AuditLog != "" {
+		file, err := os.OpenFile(
+			o.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			output.fatal("Unable to open audit log:", err)
+		}
+		defer file.Close()
+		audit = json.NewEncoder(file)
+	}
+
+	var reaper = rest.Reaper{
+		Client: client,
+		Policy: rest.ReapPolicy{
+			MaxIdle:     o.MaxIdle,
+			MaxAge:      o.MaxAge,
+			WaitForJobs: o.WaitForJobs,
+			DryRun:      o.DryRun,
+		},
+		Interval: o.Interval,
+	}
+	if o.Inventory != "" {
+		reaper.Policy.Inventory = func() ([]string, error) {
+			return readInventory(o.Inventory)
+		}
+	}
+
+	reaper.OnReap = func(action rest.ReapAction) {
+		var t = &action.Tunnel
+		var verb = "Shutting down"
+		if action.DryRun {
+			verb = "Would shut down"
+		}
+		if action.Err != nil {
+			logger.Error("Unable to shut down tunnel",
+				"tunnel", t.Id, "error", action.Err)
+		} else {
+			output.info(verb, "tunnel", t.Id, "("+action.Reason+")")
+		}
+
+		if audit != nil {
+			var message string
+			if action.Err != nil {
+				message = action.Err.Error()
+			}
+			audit.Encode(struct {
+				Time             string `json:"time"`
+				Id               string `json:"id"`
+				TunnelIdentifier string `json:"tunnel_identifier"`
+				Owner            string `json:"owner"`
+				Hostname         string `json:"hostname"`
+				Reason           string `json:"reason"`
+				DryRun           bool   `json:"dry_run"`
+				JobsRunning      int    `json:"jobs_running"`
+				Error            string `json:"error,omitempty"`
+			}{
+				action.Time.UTC().Format(time.RFC3339),
+				t.Id,
+				t.TunnelIdentifier,
+				t.Owner,
+				t.Metadata.Hostname,
+				action.Reason,
+				action.DryRun,
+				action.JobsRunning,
+				message,
+			})
+		}
+	}
+
+	if o.Once {
+		if _, err := reaper.Reap(); err != nil {
+			output.fatal("Unable to reap tunnels:", err)
+		}
+		return
+	}
+
+	if o.Interval <= 0 {
+		output.exit(exitUsage, "--interval must be positive", nil)
+	}
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var stop = make(chan struct{})
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	reaper.Run(stop, func(err error) {
+		logger.Error("Unable to reap tunnels", "error", err)
+	})
+}
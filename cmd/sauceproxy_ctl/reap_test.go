@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+func TestReadInventory(t *testing.T) {
+	for _, test := range []struct {
+		content  string
+		expected []string
+	}{
+		{"host1\nhost2\n", []string{"host1", "host2"}},
+		{"  host1  \n\n\thost2", []string{"host1", "host2"}},
+		{"# CI runners\nhost1\n  # host2\n", []string{"host1"}},
+		{"", nil},
+		{"# nothing\n\n", nil},
+	} {
+		var path = filepath.Join(t.TempDir(), "inventory")
+		if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatalf("os.WriteFile errored %+v\n", err)
+		}
+		hosts, err := readInventory(path)
+		if err != nil {
+			t.Errorf("%q: readInventory errored %+v\n", test.content, err)
+		} else if !reflect.DeepEqual(hosts, test.expected) {
+			t.Errorf("%q: got %q, expected %q", test.content, hosts, test.expected)
+		}
+	}
+
+	if _, err := readInventory(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Missing inventory file didn't error")
+	}
+}
+
+func TestReapCommand(t *testing.T) {
+	var mutex sync.Mutex
+	var deleted []string
+	var server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				fmt.Fprint(w, `[
+  {"id": "id1", "status": "running", "owner": "john", "metadata": {"hostname": "runner1"}},
+  {"id": "id2", "status": "running", "owner": "john", "metadata": {"hostname": "laptop"}},
+  {"id": "id3", "status": "shutdown", "owner": "john", "metadata": {"hostname": "laptop"}}
+]`)
+				return
+			}
+			mutex.Lock()
+			deleted = append(deleted, r.URL.Path)
+			mutex.Unlock()
+			fmt.Fprint(w, `{"result": true, "jobs_running": 2}`)
+		}))
+	defer server.Close()
+
+	var dir = t.TempDir()
+	var inventory = filepath.Join(dir, "inventory")
+	os.WriteFile(inventory, []byte("# CI\nrunner1\n"), 0600)
+
+	for _, test := range []struct {
+		dryRun  bool
+		deleted []string
+		jobs    int
+		printed string
+	}{
+		{
+			dryRun:  true,
+			printed: "Would shut down tunnel id2 (host \"laptop\" isn't in the inventory)\n",
+		},
+		{
+			deleted: []string{"/username/tunnels/id2"},
+			jobs:    2,
+			printed: "Shutting down tunnel id2 (host \"laptop\" isn't in the inventory)\n",
+		},
+	} {
+		deleted = nil
+		var printed = captureOutput(t, "text")
+		var audit = filepath.Join(t.TempDir(), "audit.log")
+		var client = &rest.Client{
+			BaseURL:  server.URL,
+			Username: "username",
+			Password: "password",
+		}
+
+		reapCommand(client, &ReapOptions{
+			Inventory: inventory,
+			AuditLog:  audit,
+			Once:      true,
+			DryRun:    test.dryRun,
+		})
+
+		if !reflect.DeepEqual(deleted, test.deleted) {
+			t.Errorf("dry run %v: deleted %q, expected %q",
+				test.dryRun, deleted, test.deleted)
+		}
+		if printed.String() != test.printed {
+			t.Errorf("dry run %v: printed %q, expected %q",
+				test.dryRun, printed, test.printed)
+		}
+
+		content, err := os.ReadFile(audit)
+		if err != nil {
+			t.Fatalf("os.ReadFile errored %+v\n", err)
+		}
+		var lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("dry run %v: got audit log %q, expected one line",
+				test.dryRun, content)
+		}
+		var entry struct {
+			Id          string `json:"id"`
+			Owner       string `json:"owner"`
+			Hostname    string `json:"hostname"`
+			Reason      string `json:"reason"`
+			DryRun      bool   `json:"dry_run"`
+			JobsRunning int    `json:"jobs_running"`
+		}
+		if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+			t.Fatalf("json.Unmarshal errored %+v\n", err)
+		}
+		if entry.Id != "id2" || entry.Owner != "john" ||
+			entry.Hostname != "laptop" || entry.DryRun != test.dryRun ||
+			entry.JobsRunning != test.jobs ||
+			entry.Reason != `host "laptop" isn't in the inventory` {
+			t.Errorf("dry run %v: invalid audit entry %+v", test.dryRun, entry)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+//
+// Create a tunnel, run `argv` while the tunnel is up, and shut the tunnel down
+// when the command exits.
+//
+// Return the exit code of the command, or exitTunnelDown if the tunnel went
+// down before the command was done.
+//
+func runCommand(client *rest.Client, o *CreateOptions, argv []string) int {
+	// Listen to signals before creating the tunnel, so a Ctrl-C while it comes
+	// up doesn't leave it running.
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	var request, timeout = o.request()
+	tunnel, sig, err := createUntilSignal(client, request, timeout, signals)
+	if sig != nil {
+		// The user doesn't want the command anymore
+		return abortCreation(tunnel, sig)
+	} else if err != nil {
+		createFailed(tunnel, err)
+	}
+	defer tunnel.Close()
+	output.info("Tunnel", tunnel.ID(), "is running")
+
+	var cmd = exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"SAUCE_TUNNEL_ID="+tunnel.ID(),
+		"SAUCE_TUNNEL_IDENTIFIER="+request.TunnelIdentifier,
+	)
+
+	// A signal received since the tunnel came up
+	select {
+	case sig := <-signals:
+		output.info("Got", sig, "before running", argv[0]+", aborting")
+		shutdownTunnel(tunnel)
+		return signalExitCode(sig)
+	default:
+	}
+
+	if err := cmd.Start(); err != nil {
+		shutdownTunnel(tunnel)
+		output.fatal(fmt.Sprintf("Unable to run %s:", argv[0]), err)
+	}
+
+	var done = make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	for {
+		select {
+		case sig := <-signals:
+			// The command decides what to do with it, we clean up once it
+			// has exited. It is in our process group, so it already got the
+			// Ctrl-C of the terminal: only forward what was sent to us.
+			if sig != os.Interrupt || !inForeground() {
+				cmd.Process.Signal(sig)
+			}
+		case status := <-tunnel.ServerStatus:
+			logger.Error("Tunnel went down, killing the command",
+				"tunnel", tunnel.ID(), "status", status, "command", argv[0])
+			cmd.Process.Kill()
+			<-done
+			return exitTunnelDown
+		case err := <-done:
+			shutdownTunnel(tunnel)
+			return commandExitCode(err)
+		}
+	}
+}
+
+//
+// Create the tunnel of `request` like CreateAndMonitor, giving up on the first
+// signal received from `signals`. That signal is returned, with the tunnel if
+// it was created by then: shut it down.
+//
+func createUntilSignal(
+	client *rest.Client,
+	request *rest.Request,
+	timeout time.Duration,
+	signals <-chan os.Signal,
+) (
+	tunnel *rest.Tunnel, sig os.Signal, err error,
+) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var created = make(chan struct{})
+	var received = make(chan os.Signal, 1)
+	go func() {
+		defer close(received)
+		select {
+		case sig := <-signals:
+			received <- sig
+			cancel()
+		case <-created:
+		}
+	}()
+
+	tunnel, err = client.CreateAndMonitorContext(ctx, request, timeout)
+	close(created)
+
+	return tunnel, <-received, err
+}
+
+//
+// Give up on the tunnel being created because of `sig`, shutting it down if
+// it exists, and return the exit code.
+//
+func abortCreation(tunnel *rest.Tunnel, sig os.Signal) int {
+	output.info("Got", sig, "while creating the tunnel, aborting")
+	if tunnel != nil {
+		tunnel.Close()
+		shutdownTunnel(tunnel)
+	}
+	return signalExitCode(sig)
+}
+
+//
+// Return the exit code of a command from the error returned by Cmd.Wait,
+// using the shell convention of 128 + signal number for killed commands.
+//
+func commandExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		logger.Error("Unable to run command", "error", err)
+		return exitError
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return exitErr.ExitCode()
+}
+
+//
+// Return the exit code of a process killed by `sig`, 128 + signal number.
+//
+func signalExitCode(sig os.Signal) int {
+	if number, ok := sig.(syscall.Signal); ok {
+		return 128 + int(number)
+	}
+	return exitError
+}
@@ -0,0 +1,258 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+	"github.com/saucelabs/sauceproxy-rest/internal/hooks"
+)
+
+//
+// Return a client of a fake REST API whose tunnels come up right away, the
+// number of tunnels shut down, and call `onWait` on the first status query.
+// The next status queries get `down` if set, and the status loop polls
+// every 50ms.
+//
+func tunnelServer(t *testing.T, onWait func(), down string) (*rest.Client, *int32) {
+	var shutdowns, statuses int32
+	var once sync.Once
+	var server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/tunnels"):
+				fmt.Fprint(w, `{"id": "fakeid", "host": "HOSTNAME"}`)
+			case r.Method == "GET":
+				once.Do(onWait)
+				// The tunnel comes up, then goes down if `down` is set
+				if atomic.AddInt32(&statuses, 1) > 1 && down != "" {
+					fmt.Fprint(w, down)
+					return
+				}
+				fmt.Fprint(w, `{"id": "fakeid", "status": "running", "host": "HOSTNAME", "ip_address": "1.2.3.4"}`)
+			case r.Method == "DELETE":
+				atomic.AddInt32(&shutdowns, 1)
+				fmt.Fprint(w, `{"result": true, "id": "fakeid", "jobs_running": 0}`)
+			default:
+				fmt.Fprint(w, `{"result": true, "id": "fakeid"}`)
+			}
+		}))
+	t.Cleanup(server.Close)
+
+	var client = &rest.Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+	hooks.SetLoopIntervals(client, 50*time.Millisecond, 30*time.Second)
+
+	return client, &shutdowns
+}
+
+func TestRunCommand(t *testing.T) {
+	var foreground = inForeground()
+
+	for _, test := range []struct {
+		name     string
+		argv     []string
+		signal   syscall.Signal
+		created  bool   // Send the signal while the tunnel comes up
+		down     string // Status of the tunnel once the command runs
+		expected int
+		ran      bool
+	}{
+		{
+			name:     "success",
+			argv:     []string{"true"},
+			expected: 0,
+			ran:      true,
+		},
+		{
+			name:     "exit code",
+			argv:     []string{"sh", "-c", "exit 3"},
+			expected: 3,
+			ran:      true,
+		},
+		{
+			name:     "killed",
+			argv:     []string{"sh", "-c", "kill -TERM $$"},
+			expected: 128 + int(syscall.SIGTERM),
+			ran:      true,
+		},
+		{
+			name:     "signal while creating",
+			argv:     []string{"true"},
+			signal:   syscall.SIGHUP,
+			created:  true,
+			expected: 128 + int(syscall.SIGHUP),
+		},
+		{
+			name:     "Ctrl-C while creating",
+			argv:     []string{"true"},
+			signal:   syscall.SIGINT,
+			created:  true,
+			expected: 128 + int(syscall.SIGINT),
+		},
+		{
+			name:     "SIGTERM forwarded",
+			argv:     []string{"sleep", "10"},
+			signal:   syscall.SIGTERM,
+			expected: 128 + int(syscall.SIGTERM),
+			ran:      true,
+		},
+		{
+			name:     "SIGINT forwarded",
+			argv:     []string{"sleep", "10"},
+			signal:   syscall.SIGINT,
+			expected: 128 + int(syscall.SIGINT),
+			ran:      true,
+		},
+		{
+			name:     "tunnel down",
+			argv:     []string{"sleep", "10"},
+			down:     `{"id": "fakeid", "status": "terminated"}`,
+			expected: exitTunnelDown,
+			ran:      true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.signal == syscall.SIGINT && !test.created && foreground {
+				t.Skip("SIGINT isn't forwarded in the foreground of a terminal")
+			}
+
+			var kill = func() {
+				syscall.Kill(os.Getpid(), test.signal)
+				// Let the signal reach the channel of runCommand
+				time.Sleep(100 * time.Millisecond)
+			}
+			var onWait = func() {}
+			if test.signal != 0 && test.created {
+				onWait = kill
+			}
+			client, shutdowns := tunnelServer(t, onWait, test.down)
+
+			// Record whether the command was started
+			var ran = filepath.Join(t.TempDir(), "ran")
+			var argv = []string{"sh", "-c",
+				`touch "$0" && exec "$@"`, ran}
+			argv = append(argv, test.argv...)
+
+			if test.signal != 0 && !test.created {
+				go func() {
+					time.Sleep(500 * time.Millisecond)
+					kill()
+				}()
+			}
+
+			var code = runCommand(client, &CreateOptions{}, argv)
+			if code != test.expected {
+				t.Errorf("Got exit code %d, expected %d", code, test.expected)
+			}
+			if _, err := os.Stat(ran); (err == nil) != test.ran {
+				t.Errorf("Command ran: %v, expected %v", err == nil, test.ran)
+			}
+			// A tunnel that went down isn't shut down again
+			var expected int32 = 1
+			if test.down != "" {
+				expected = 0
+			}
+			if n := atomic.LoadInt32(shutdowns); n != expected {
+				t.Errorf("Tunnel shut down %d times, expected %d", n, expected)
+			}
+		})
+	}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	for _, test := range []struct {
+		err      error
+		expected int
+	}{
+		{nil, 0},
+		{fmt.Errorf("exec: not found"), exitError},
+		{&os.PathError{Op: "fork/exec", Path: "missing", Err: os.ErrNotExist}, exitError},
+	} {
+		if code := commandExitCode(test.err); code != test.expected {
+			t.Errorf("%v: got %d, expected %d", test.err, code, test.expected)
+		}
+	}
+
+	for _, test := range []struct {
+		signal   os.Signal
+		expected int
+	}{
+		{syscall.SIGINT, 130},
+		{syscall.SIGTERM, 143},
+		{syscall.SIGHUP, 129},
+	} {
+		if code := signalExitCode(test.signal); code != test.expected {
+			t.Errorf("%v: got %d, expected %d", test.signal, code, test.expected)
+		}
+	}
+}
+
+// A signal stops waiting for a tunnel that doesn't come up, and shuts it down
+func TestSignalWhileCreating(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		command func(*rest.Client) int
+	}{
+		{"run", func(client *rest.Client) int {
+			return runCommand(client, &CreateOptions{}, []string{"true"})
+		}},
+		{"up", func(client *rest.Client) int {
+			return upCommand(client, &CreateOptions{})
+		}},
+	} {
+		captureOutput(t, "text")
+		var shutdowns int32
+		var server = httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case "POST":
+					fmt.Fprint(w, `{"id": "fakeid"}`)
+				case "GET":
+					fmt.Fprint(w, `{"id": "fakeid", "status": "new"}`)
+				case "DELETE":
+					atomic.AddInt32(&shutdowns, 1)
+					fmt.Fprint(w, `{"result": true, "id": "fakeid", "jobs_running": 0}`)
+				}
+			}))
+		var client = &rest.Client{
+			BaseURL:  server.URL,
+			Username: "username",
+			Password: "password",
+		}
+
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}()
+
+		var start = time.Now()
+		if code := test.command(client); code != 128+int(syscall.SIGTERM) {
+			t.Errorf("%s: got exit code %d, expected %d",
+				test.name, code, 128+int(syscall.SIGTERM))
+		}
+		// The tunnel would time out after a minute
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: returned after %s", test.name, elapsed)
+		}
+		if n := atomic.LoadInt32(&shutdowns); n != 1 {
+			t.Errorf("%s: tunnel shut down %d times, expected once",
+				test.name, n)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+type ShutdownOptions struct {
+	OwnerOptions
+
+	All         bool          `long:"all" description:"Select all the tunnels."`
+	Identifier  string        `long:"identifier" value-name:"<name>" description:"Select the tunnels with this tunnel identifier."`
+	OlderThan   time.Duration `long:"older-than" value-name:"<duration>" description:"Select the tunnels created more than this long ago (example: 6h)."`
+	Status      string        `long:"status" value-name:"<status>" description:"Select the tunnels with this status (example: running)."`
+	DryRun      bool          `long:"dry-run" description:"Show the tunnels that would be shut down, and exit."`
+	Yes         bool          `short:"y" long:"yes" description:"Don't ask for confirmation before shutting down the selected tunnels."`
+	WaitForJobs bool          `long:"wait-for-jobs" description:"Let the jobs running on the tunnels finish before they go down."`
+	Concurrency int           `long:"concurrency" value-name:"<n>" description:"Number of tunnels shut down at the same time." default:"4"`
+	Arg         struct {
+		Ids []string `positional-arg-name:"id" description:"Tunnel IDs (not tunnel identifiers)"`
+	} `positional-args:"yes"`
+}
+
+func (o *ShutdownOptions) hasSelectors() bool {
+	return o.All || o.Identifier != "" || o.OlderThan != 0 || o.Status != ""
+}
+
+//
+// Return true if the tunnel matches all the selectors.
+//
+func (o *ShutdownOptions) selects(now time.Time, t *rest.TunnelInfo) bool {
+	if o.Identifier != "" && t.TunnelIdentifier != o.Identifier {
+		return false
+	}
+	if o.OlderThan != 0 &&
+		now.Sub(time.Unix(t.CreationTime, 0)) < o.OlderThan {
+		return false
+	}
+	if o.Status != "" && t.State() != o.Status {
+		return false
+	}
+
+	return true
+}
+
+//
+// Ask the user to confirm on the terminal, refuse if stdin isn't one.
+//
+func confirm(question string) bool {
+	var stat, err = os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		output.exit(exitUsage,
+			"Not a terminal, use --yes to shut down the tunnels", nil)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+//
+// Return placeholders for tunnels we only know the IDs of.
+//
+func infos(ids []string, owner string) (tunnels []rest.TunnelInfo) {
+	for _, id := range ids {
+		tunnels = append(tunnels, rest.TunnelInfo{Id: id, Owner: owner})
+	}
+
+	return
+}
+
+//
+// Shut down the tunnels, going through the account owning each of them.
+//
+func shutdownTunnels(
+	client *rest.Client,
+	tunnels []rest.TunnelInfo,
+	opts rest.ShutdownOptions,
+) (
+	results []rest.ShutdownResult, jobsRunning int, err error,
+) {
+	var owners []string
+	var ids = map[string][]string{}
+	for _, t := range tunnels {
+		if _, ok := ids[t.Owner]; !ok {
+			owners = append(owners, t.Owner)
+		}
+		ids[t.Owner] = append(ids[t.Owner], t.Id)
+	}
+
+	var errs []error
+	for _, owner := range owners {
+		opts.Owner = owner
+		r, jobs, err := client.ShutdownMany(ids[owner], opts)
+		results = append(results, r...)
+		jobsRunning += jobs
+		errs = append(errs, err)
+	}
+
+	return results, jobsRunning, errors.Join(errs...)
+}
+
+func shutdownCommand(client *rest.Client, o *ShutdownOptions) {
+	var ids = o.Arg.Ids
+
+	switch {
+	case len(ids) == 0 && !o.hasSelectors():
+		output.exit(exitUsage,
+			"Give tunnel IDs, or select tunnels with --all, --identifier, "+
+				"--older-than or --status", nil)
+	case len(ids) > 0 && o.hasSelectors():
+		output.exit(exitUsage, "Tunnel IDs and selectors are exclusive", nil)
+	}
+
+	var selected = infos(ids, o.Owner)
+	if o.hasSelectors() {
+		tunnels, err := client.ListDetailed(o.listOptions())
+		if err != nil {
+			output.fatal("Unable to list tunnels:", err)
+		}
+
+		var now = time.Now()
+		for i := range tunnels {
+			if o.selects(now, &tunnels[i]) {
+				selected = append(selected, tunnels[i])
+			}
+		}
+
+		if o.DryRun {
+			output.printTunnels(selected)
+			return
+		}
+		if len(selected) == 0 {
+			output.info("No tunnel matches")
+			return
+		}
+		if !o.Yes {
+			// Show what we're about to do next to the question
+			var preview = printer{format: "table", out: os.Stderr, err: os.Stderr}
+			preview.printTunnels(selected)
+			if !confirm(fmt.Sprintf("Shut down %d tunnels?", len(selected))) {
+				output.exit(exitError, "Aborted", nil)
+			}
+		}
+	} else if o.DryRun {
+		output.printTunnels(selected)
+		return
+	}
+
+	results, jobsRunning, err := shutdownTunnels(client, selected,
+		rest.ShutdownOptions{
+			Concurrency: o.Concurrency,
+			WaitForJobs: o.WaitForJobs,
+		})
+
+	type jsonResult struct {
+		Id                string `json:"id"`
+		JobsRunning       int    `json:"jobs_running"`
+		AlreadyTerminated bool   `json:"already_terminated"`
+		Error             string `json:"error,omitempty"`
+	}
+	var doc = struct {
+		Results     []jsonResult `json:"results"`
+		JobsRunning int          `json:"jobs_running"`
+	}{[]jsonResult{}, jobsRunning}
+	var rows = [][]string{{"ID", "RESULT", "JOBS RUNNING"}}
+
+	for _, r := range results {
+		var result = "shutting down"
+		var message = ""
+		switch {
+		case r.Err != nil:
+			result = "error: " + r.Err.Error()
+			message = r.Err.Error()
+			output.info("Unable to shutdown tunnel", r.Id+":", r.Err)
+		case r.AlreadyTerminated:
+			result = "already terminated"
+			output.info("Tunnel", r.Id, "already terminated.")
+		default:
+			output.info("Tunnel", r.Id, "shutting down.")
+		}
+		doc.Results = append(doc.Results, jsonResult{
+			r.Id, r.JobsRunning, r.AlreadyTerminated, message,
+		})
+		rows = append(rows, []string{r.Id, result, fmt.Sprint(r.JobsRunning)})
+	}
+	output.print(&doc, rows, "")
+
+	if err != nil {
+		output.fatal("Unable to shutdown all the tunnels:", err)
+	}
+}
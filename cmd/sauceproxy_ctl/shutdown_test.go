@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+func TestShutdownOptionsSelects(t *testing.T) {
+	var now = time.Unix(1700000000, 0)
+	var tunnel = rest.TunnelInfo{
+		Id:               "id1",
+		TunnelIdentifier: "ci",
+		Status:           "running",
+		CreationTime:     now.Add(-2 * time.Hour).Unix(),
+	}
+
+	for _, test := range []struct {
+		options  ShutdownOptions
+		expected bool
+	}{
+		{ShutdownOptions{All: true}, true},
+		{ShutdownOptions{Identifier: "ci"}, true},
+		{ShutdownOptions{Identifier: "other"}, false},
+		{ShutdownOptions{OlderThan: time.Hour}, true},
+		{ShutdownOptions{OlderThan: 3 * time.Hour}, false},
+		{ShutdownOptions{Status: "running"}, true},
+		{ShutdownOptions{Status: "new"}, false},
+		// All the selectors must match
+		{ShutdownOptions{Identifier: "ci", OlderThan: time.Hour, Status: "running"}, true},
+		{ShutdownOptions{Identifier: "ci", OlderThan: time.Hour, Status: "new"}, false},
+		{ShutdownOptions{All: true, Identifier: "other"}, false},
+	} {
+		if selected := test.options.selects(now, &tunnel); selected != test.expected {
+			t.Errorf("%+v: got %v, expected %v", test.options, selected, test.expected)
+		}
+	}
+}
+
+func TestShutdownCommand(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	var server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests = append(requests, r.Method+" "+r.URL.RequestURI())
+			mutex.Unlock()
+
+			switch {
+			case r.Method == "GET" && r.URL.Query().Get("all") == "1":
+				fmt.Fprint(w, `[
+  {"id": "id1", "tunnel_identifier": "ci", "status": "running"},
+  {"id": "id4", "tunnel_identifier": "ci", "status": "running", "owner": "dev"}
+]`)
+			case r.Method == "GET":
+				fmt.Fprint(w, `[
+  {"id": "id1", "tunnel_identifier": "ci", "status": "running"},
+  {"id": "id2", "tunnel_identifier": "other", "status": "running"},
+  {"id": "id3", "tunnel_identifier": "ci", "status": "new"}
+]`)
+			case strings.HasSuffix(r.URL.Path, "/gone"):
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error": "not found"}`)
+			default:
+				fmt.Fprint(w, `{"result": true, "jobs_running": 1}`)
+			}
+		}))
+	defer server.Close()
+	var client = &rest.Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	for _, test := range []struct {
+		name     string
+		options  ShutdownOptions
+		ids      []string
+		requests []string
+		printed  string
+	}{
+		{
+			// Single IDs go through ShutdownMany too
+			name:     "single ID",
+			options:  ShutdownOptions{WaitForJobs: true},
+			ids:      []string{"id1"},
+			requests: []string{"DELETE /username/tunnels/id1?wait_for_jobs=1"},
+			printed: "Tunnel id1 shutting down.\n" +
+				"ID   RESULT         JOBS RUNNING\n" +
+				"id1  shutting down  1\n",
+		},
+		{
+			name:     "single ID already terminated",
+			ids:      []string{"gone"},
+			requests: []string{"DELETE /username/tunnels/gone?wait_for_jobs=0"},
+			printed: "Tunnel gone already terminated.\n" +
+				"ID    RESULT              JOBS RUNNING\n" +
+				"gone  already terminated  0\n",
+		},
+		{
+			name:     "owner",
+			options:  ShutdownOptions{OwnerOptions: OwnerOptions{Owner: "team"}},
+			ids:      []string{"id1", "id2"},
+			requests: []string{"DELETE /team/tunnels/id1?wait_for_jobs=0", "DELETE /team/tunnels/id2?wait_for_jobs=0"},
+		},
+		{
+			name:    "selectors",
+			options: ShutdownOptions{Identifier: "ci", Status: "running", Yes: true},
+			requests: []string{
+				"DELETE /username/tunnels/id1?wait_for_jobs=0",
+				"GET /username/tunnels?full=1",
+			},
+		},
+		{
+			// The shared tunnels are shut down through their owner
+			name:    "shared",
+			options: ShutdownOptions{OwnerOptions: OwnerOptions{IncludeShared: true}, Identifier: "ci", Yes: true},
+			requests: []string{
+				"DELETE /dev/tunnels/id4?wait_for_jobs=0",
+				"DELETE /username/tunnels/id1?wait_for_jobs=0",
+				"GET /username/tunnels?full=1&all=1",
+			},
+		},
+		{
+			name:     "dry run",
+			options:  ShutdownOptions{Identifier: "ci", DryRun: true},
+			requests: []string{"GET /username/tunnels?full=1"},
+			printed: "ID   IDENTIFIER  OWNER     STATUS   HOST  IP  DOMAINS  CREATED\n" +
+				"id1  ci          username  running                     \n" +
+				"id3  ci          username  new                         \n",
+		},
+	} {
+		requests = nil
+		var printed = captureOutput(t, "table")
+		var options = test.options
+		options.Arg.Ids = test.ids
+
+		shutdownCommand(client, &options)
+
+		sort.Strings(requests)
+		if strings.Join(requests, "\n") != strings.Join(test.requests, "\n") {
+			t.Errorf("%s: got requests %q, expected %q", test.name, requests, test.requests)
+		}
+		if test.printed != "" && printed.String() != test.printed {
+			t.Errorf("%s: printed %q, expected %q", test.name, printed, test.printed)
+		}
+	}
+}
+
+// IDs that would change the path of the request are rejected
+func TestShutdownCommandInvalidID(t *testing.T) {
+	var requests int32
+	var server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			fmt.Fprint(w, `{"result": true, "jobs_running": 0}`)
+		}))
+	defer server.Close()
+	var client = &rest.Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	for _, id := range []string{"", ".", ".."} {
+		var printed = captureOutput(t, "text")
+		var options = ShutdownOptions{}
+		options.Arg.Ids = []string{id}
+
+		var code = catchExit(func() { shutdownCommand(client, &options) })
+		if code != exitError {
+			t.Errorf("%q: exited with %d, expected %d", id, code, exitError)
+		}
+		if strings.Contains(printed.String(), "shutting down") {
+			t.Errorf("%q: printed %q", id, printed)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("%d requests sent, expected none", n)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+//
+// Return the transport of the requests with the TLS and proxy settings of
+// `options`, the pins applying to the REST API host only.
+//
+func newTransport(options *CommonOptions) (*http.Transport, error) {
+	var tlsOptions = rest.TLSOptions{
+		CAFile:     options.CaFile,
+		ClientCert: options.ClientCert,
+		ClientKey:  options.ClientKey,
+		Pins:       options.PinSha256,
+	}
+
+	version, err := rest.ParseTLSVersion(options.TlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsOptions.MinVersion = version
+
+	if len(options.PinSha256) > 0 {
+		u, err := url.Parse(options.RestUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REST URL %s: %s", options.RestUrl, err)
+		}
+		tlsOptions.PinnedHost = u.Hostname()
+	}
+
+	transport, err := tlsOptions.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	var proxyConfig = rest.ProxyConfig{
+		URL:     options.Proxy,
+		NoProxy: options.NoProxy,
+	}
+
+	var userpwd = options.ProxyUserpwd
+	if options.ProxyUserpwdFile != "" {
+		if userpwd != "" {
+			return nil, fmt.Errorf(
+				"--proxy-userpwd and --proxy-userpwd-file are exclusive")
+		}
+		data, err := os.ReadFile(options.ProxyUserpwdFile)
+		if err != nil {
+			return nil, err
+		}
+		userpwd = strings.TrimSpace(string(data))
+		if userpwd == "" {
+			return nil, fmt.Errorf("%s is empty", options.ProxyUserpwdFile)
+		}
+	}
+	if userpwd != "" {
+		var user, password, _ = strings.Cut(userpwd, ":")
+		proxyConfig.Username, proxyConfig.Password = user, password
+	}
+	if transport.Proxy, err = proxyConfig.ProxyFunc(); err != nil {
+		return nil, err
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+//
+// Create a tunnel and keep it alive in the foreground until it goes down or we
+// get a signal. The first SIGINT or SIGTERM shuts the tunnel down once its
+// jobs are done, the second one shuts it down right away.
+//
+// Return 0 if we asked for the shutdown, or exitTunnelDown if the tunnel went
+// down on its own (for example shutdown by the user from the web interface).
+// A signal received while the tunnel comes up aborts its creation, and the
+// exit code is then 128 + the signal number.
+//
+func upCommand(client *rest.Client, o *CreateOptions) int {
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var request, timeout = o.request()
+	output.info("Creating tunnel")
+	tunnel, sig, err := createUntilSignal(client, request, timeout, signals)
+	if sig != nil {
+		return abortCreation(tunnel, sig)
+	} else if err != nil {
+		createFailed(tunnel, err)
+	}
+	defer tunnel.Close()
+	printStateChanges(tunnel)
+	var endpoint = tunnel.KGPEndpoint()
+	output.info("Tunnel", tunnel.ID(), "is running, KGP host:",
+		endpoint.Host, endpoint.Ip)
+	output.printTunnel(
+		&rest.TunnelInfo{
+			Id:               tunnel.ID(),
+			TunnelIdentifier: request.TunnelIdentifier,
+			Status:           "running",
+			Host:             endpoint.Host,
+			Ip:               endpoint.Ip,
+			DomainNames:      request.DomainNames,
+		},
+		tunnel.ID()+"\n")
+
+	var shuttingDown = false
+	var shutdownErrors = make(chan error, 2)
+
+	for {
+		select {
+		case <-signals:
+			if !shuttingDown {
+				shuttingDown = true
+				output.info("Tunnel", tunnel.ID(),
+					"shutting down once its jobs are done, signal again to force")
+				go func() {
+					jobs, err := tunnel.ShutdownWaitForJobs()
+					if err == nil {
+						output.info("Tunnel", tunnel.ID(), "has", jobs, "jobs running")
+					}
+					shutdownErrors <- err
+				}()
+			} else {
+				output.info("Tunnel", tunnel.ID(), "shutting down now")
+				if _, err := tunnel.Shutdown(); err != nil {
+					output.fatal("Unable to shutdown tunnel:", err)
+				}
+				return 0
+			}
+		case err := <-shutdownErrors:
+			if err != nil {
+				output.fatal("Unable to shutdown tunnel:", err)
+			}
+		case endpoint := <-tunnel.KGPChanges:
+			output.info("Tunnel", tunnel.ID(), "moved, KGP host:",
+				endpoint.Host, endpoint.Ip)
+		case change := <-tunnel.StateChanges:
+			printStateChange(tunnel, change)
+		case status := <-tunnel.ServerStatus:
+			// The change to this status was sent first
+			printStateChanges(tunnel)
+			if status == "user shutdown" || !shuttingDown {
+				return exitTunnelDown
+			}
+			return 0
+		}
+	}
+}
+
+//
+// Print a status change of the tunnel, they are only logged at info.
+//
+func printStateChange(tunnel *rest.Tunnel, change rest.StateChange) {
+	output.info("Tunnel", tunnel.ID(), "status changed:",
+		change.From, "->", change.To)
+}
+
+//
+// Print the status changes of the tunnel not received yet.
+//
+func printStateChanges(tunnel *rest.Tunnel) {
+	for {
+		select {
+		case change := <-tunnel.StateChanges:
+			printStateChange(tunnel, change)
+		default:
+			return
+		}
+	}
+}
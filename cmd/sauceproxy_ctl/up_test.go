@@ -0,0 +1,75 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUpCommand(t *testing.T) {
+	var printed = captureOutput(t, "text")
+	client, shutdowns := tunnelServer(t, func() {}, "")
+
+	go func() {
+		// The first signal waits for the jobs, the second one forces
+		for i := 0; i < 2; i++ {
+			time.Sleep(300 * time.Millisecond)
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}
+	}()
+
+	if code := upCommand(client, &CreateOptions{}); code != 0 {
+		t.Errorf("Got exit code %d, expected 0", code)
+	}
+	if n := atomic.LoadInt32(shutdowns); n != 2 {
+		t.Errorf("Tunnel shut down %d times, expected twice", n)
+	}
+	for _, line := range []string{
+		"Tunnel fakeid status changed: new -> running",
+		"Tunnel fakeid is running, KGP host: HOSTNAME 1.2.3.4",
+		"Tunnel fakeid shutting down once its jobs are done",
+		"Tunnel fakeid shutting down now",
+	} {
+		if !strings.Contains(printed.String(), line) {
+			t.Errorf("Missing %q in the output:\n%s", line, printed)
+		}
+	}
+}
+
+func TestUpCommandTunnelDown(t *testing.T) {
+	for _, test := range []struct {
+		down    string
+		printed string
+	}{
+		{
+			down:    `{"id": "fakeid", "status": "running", "user_shutdown": true}`,
+			printed: "Tunnel fakeid status changed: running -> user shutdown",
+		},
+		{
+			// Went down without a signal
+			down:    `{"id": "fakeid", "status": "terminated"}`,
+			printed: "Tunnel fakeid status changed: running -> terminated",
+		},
+	} {
+		var printed = captureOutput(t, "text")
+		client, shutdowns := tunnelServer(t, func() {}, test.down)
+
+		if code := upCommand(client, &CreateOptions{}); code != exitTunnelDown {
+			t.Errorf("%s: got exit code %d, expected %d",
+				test.down, code, exitTunnelDown)
+		}
+		if n := atomic.LoadInt32(shutdowns); n != 0 {
+			t.Errorf("%s: tunnel shut down %d times, expected none",
+				test.down, n)
+		}
+		if !strings.Contains(printed.String(), test.printed) {
+			t.Errorf("%s: missing %q in the output:\n%s",
+				test.down, test.printed, printed)
+		}
+	}
+}
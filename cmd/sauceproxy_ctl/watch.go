@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+type WatchOptions struct {
+	OwnerOptions
+	MetricsOptions
+
+	Identifier string        `long:"identifier" value-name:"<name>" description:"Only watch the tunnels with this tunnel identifier."`
+	All        bool          `long:"all" description:"Watch all the tunnels (default when no ID or identifier is given)."`
+	Interval   time.Duration `long:"interval" value-name:"<duration>" description:"Time between two refreshes, at least 1s." default:"5s"`
+	Arg        struct {
+		Id string `positional-arg-name:"id" description:"Tunnel ID (not tunnel identifier)"`
+	} `positional-args:"yes"`
+}
+
+//
+// Return true if the tunnel is one we were asked to watch.
+//
+func (o *WatchOptions) selects(t *rest.TunnelInfo) bool {
+	switch {
+	case o.All:
+		return true
+	case o.Arg.Id != "":
+		return t.Id == o.Arg.Id
+	case o.Identifier != "":
+		return t.TunnelIdentifier == o.Identifier
+	}
+
+	return true
+}
+
+//
+// Format the time elapsed since the Unix timestamp `t`.
+//
+func since(now time.Time, t int64) string {
+	if t == 0 {
+		return "-"
+	}
+
+	return now.Sub(time.Unix(t, 0)).Round(time.Second).String()
+}
+
+func watchRow(now time.Time, t *rest.TunnelInfo) []string {
+	var lastConnected = "-"
+	if t.LastConnected != nil {
+		lastConnected = since(now, *t.LastConnected)
+	}
+	var jobs = "-"
+	if t.JobsRunning != nil {
+		jobs = fmt.Sprint(*t.JobsRunning)
+	}
+
+	return []string{
+		t.Id,
+		t.TunnelIdentifier,
+		t.Owner,
+		t.State(),
+		since(now, t.CreationTime),
+		lastConnected,
+		jobs,
+		strings.TrimSpace(t.Host + " " + t.Ip),
+	}
+}
+
+// Shortest --interval, to not hammer the REST API
+const minWatchInterval = time.Second
+
+//
+// Poll the tunnel list every `o.Interval` until interrupted. On a terminal
+// the screen is redrawn with the current table, otherwise each status change
+// is printed on its own line.
+//
+func watchCommand(client *rest.Client, o *WatchOptions) {
+	if o.Interval < minWatchInterval {
+		output.exit(exitUsage, fmt.Sprintf(
+			"--interval must be at least %s", minWatchInterval), nil)
+	}
+	var stat, err = os.Stdout.Stat()
+	var tty = err == nil && stat.Mode()&os.ModeCharDevice != 0
+
+	// Last known state of each tunnel, by ID
+	var previous = map[string]rest.TunnelInfo{}
+
+	for {
+		tunnels, err := client.ListDetailed(o.listOptions())
+		var now = time.Now()
+
+		if err != nil {
+			logger.Error("Unable to list tunnels", "error", err)
+		} else {
+			var selected []rest.TunnelInfo
+			for i := range tunnels {
+				if o.selects(&tunnels[i]) {
+					selected = append(selected, tunnels[i])
+					if client.Metrics != nil {
+						client.Metrics.TunnelStatus(
+							tunnels[i].Id, tunnels[i].State())
+					}
+				}
+			}
+			sort.Slice(selected, func(i, j int) bool {
+				return selected[i].CreationTime < selected[j].CreationTime
+			})
+
+			if tty {
+				drawWatchTable(now, o.Interval, selected)
+			} else {
+				printTransitions(now, previous, selected)
+			}
+			previous = map[string]rest.TunnelInfo{}
+			for _, t := range selected {
+				previous[t.Id] = t
+			}
+		}
+
+		time.Sleep(o.Interval)
+	}
+}
+
+func drawWatchTable(
+	now time.Time,
+	interval time.Duration,
+	tunnels []rest.TunnelInfo,
+) {
+	// Move to the top left corner and clear the screen
+	fmt.Fprint(output.out, "\033[H\033[2J")
+	fmt.Fprintf(output.out, "Every %s: %s\n\n", interval, now.Format(time.RFC3339))
+
+	var w = tabwriter.NewWriter(output.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tIDENTIFIER\tOWNER\tSTATUS\tAGE\tLAST CONNECTED\tJOBS\tKGP HOST")
+	for i := range tunnels {
+		fmt.Fprintln(w, strings.Join(watchRow(now, &tunnels[i]), "\t"))
+	}
+	w.Flush()
+}
+
+//
+// Print a line for each tunnel whose state is different from `previous`,
+// including the ones that appeared or vanished from the list.
+//
+func printTransitions(
+	now time.Time,
+	previous map[string]rest.TunnelInfo,
+	tunnels []rest.TunnelInfo,
+) {
+	var report = func(t *rest.TunnelInfo, from, to string) {
+		if output.format == "json" {
+			json.NewEncoder(output.out).Encode(struct {
+				Time             string `json:"time"`
+				Id               string `json:"id"`
+				TunnelIdentifier string `json:"tunnel_identifier"`
+				Owner            string `json:"owner"`
+				From             string `json:"from"`
+				To               string `json:"to"`
+			}{now.Format(time.RFC3339), t.Id, t.TunnelIdentifier, t.Owner, from, to})
+		} else {
+			fmt.Fprintf(output.out, "%s %s %q %s -> %s\n",
+				now.Format(time.RFC3339), t.Id, t.TunnelIdentifier, from, to)
+		}
+	}
+
+	var seen = map[string]bool{}
+	for i := range tunnels {
+		var t = &tunnels[i]
+		var from = "none"
+		if p, ok := previous[t.Id]; ok {
+			from = p.State()
+		}
+		if state := t.State(); state != from {
+			report(t, from, state)
+		}
+		seen[t.Id] = true
+	}
+
+	var gone []string
+	for id := range previous {
+		if !seen[id] {
+			gone = append(gone, id)
+		}
+	}
+	sort.Strings(gone)
+	for _, id := range gone {
+		var t = previous[id]
+		report(&t, t.State(), "gone")
+	}
+}
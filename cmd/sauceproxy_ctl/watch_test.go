@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/saucelabs/sauceproxy-rest"
+)
+
+func TestWatchOptionsSelects(t *testing.T) {
+	var tunnel = rest.TunnelInfo{Id: "id1", TunnelIdentifier: "ci"}
+
+	for _, test := range []struct {
+		all        bool
+		identifier string
+		id         string
+		expected   bool
+	}{
+		{expected: true},
+		{all: true, expected: true},
+		{identifier: "ci", expected: true},
+		{identifier: "other", expected: false},
+		// --all wins over the other selectors
+		{all: true, identifier: "other", expected: true},
+		// So does the ID over the identifier
+		{identifier: "ci", id: "id2", expected: false},
+		{identifier: "other", id: "id1", expected: true},
+	} {
+		var options = WatchOptions{All: test.all, Identifier: test.identifier}
+		options.Arg.Id = test.id
+		if selected := options.selects(&tunnel); selected != test.expected {
+			t.Errorf("%+v: got %v, expected %v", test, selected, test.expected)
+		}
+	}
+}
+
+func TestWatchRow(t *testing.T) {
+	var now = time.Unix(1700000000, 0)
+	var connected = now.Add(-90 * time.Second).Unix()
+
+	for _, test := range []struct {
+		tunnel   rest.TunnelInfo
+		expected []string
+	}{
+		{
+			tunnel: rest.TunnelInfo{
+				Id:               "id1",
+				TunnelIdentifier: "ci",
+				Owner:            "john",
+				Status:           "running",
+				CreationTime:     now.Add(-time.Hour).Unix(),
+				LastConnected:    &connected,
+				JobsRunning:      intPtr(3),
+				Host:             "maki1.miso.saucelabs.com",
+				Ip:               "1.2.3.4",
+			},
+			expected: []string{"id1", "ci", "john", "running", "1h0m0s",
+				"1m30s", "3", "maki1.miso.saucelabs.com 1.2.3.4"},
+		},
+		{
+			// Nothing known yet
+			tunnel: rest.TunnelInfo{Id: "id2", Status: "new"},
+			expected: []string{"id2", "", "", "new", "-",
+				"-", "-", ""},
+		},
+		{
+			tunnel: rest.TunnelInfo{
+				Id:           "id3",
+				Status:       "running",
+				UserShutdown: boolPtr(true),
+				Ip:           "1.2.3.4",
+			},
+			expected: []string{"id3", "", "", "user shutdown", "-",
+				"-", "-", "1.2.3.4"},
+		},
+	} {
+		if row := watchRow(now, &test.tunnel); !reflect.DeepEqual(row, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.tunnel.Id, row, test.expected)
+		}
+	}
+}
+
+func TestPrintTransitions(t *testing.T) {
+	var now = time.Unix(1700000000, 0).UTC()
+	var running = rest.TunnelInfo{
+		Id: "id1", TunnelIdentifier: "ci", Owner: "john", Status: "running",
+	}
+	var starting = running
+	starting.Status = "starting"
+	var shutdown = running
+	shutdown.UserShutdown = boolPtr(true)
+	var other = rest.TunnelInfo{Id: "id2", Status: "running"}
+
+	for _, test := range []struct {
+		name     string
+		format   string
+		previous []rest.TunnelInfo
+		tunnels  []rest.TunnelInfo
+		expected string
+	}{
+		{
+			name:     "unchanged",
+			format:   "text",
+			previous: []rest.TunnelInfo{running},
+			tunnels:  []rest.TunnelInfo{running},
+		},
+		{
+			name:     "new",
+			format:   "text",
+			tunnels:  []rest.TunnelInfo{running},
+			expected: "2023-11-14T22:13:20Z id1 \"ci\" none -> running\n",
+		},
+		{
+			name:     "changed",
+			format:   "text",
+			previous: []rest.TunnelInfo{starting, other},
+			tunnels:  []rest.TunnelInfo{running, other},
+			expected: "2023-11-14T22:13:20Z id1 \"ci\" starting -> running\n",
+		},
+		{
+			name:     "user shutdown",
+			format:   "text",
+			previous: []rest.TunnelInfo{running},
+			tunnels:  []rest.TunnelInfo{shutdown},
+			expected: "2023-11-14T22:13:20Z id1 \"ci\" running -> user shutdown\n",
+		},
+		{
+			name:     "gone",
+			format:   "text",
+			previous: []rest.TunnelInfo{other, running},
+			expected: "2023-11-14T22:13:20Z id1 \"ci\" running -> gone\n" +
+				"2023-11-14T22:13:20Z id2 \"\" running -> gone\n",
+		},
+		{
+			name:     "json",
+			format:   "json",
+			previous: []rest.TunnelInfo{starting},
+			tunnels:  []rest.TunnelInfo{running},
+			expected: `{"time":"2023-11-14T22:13:20Z","id":"id1","tunnel_identifier":"ci","owner":"john","from":"starting","to":"running"}` + "\n",
+		},
+	} {
+		var printed = captureOutput(t, test.format)
+		var previous = map[string]rest.TunnelInfo{}
+		for _, tunnel := range test.previous {
+			previous[tunnel.Id] = tunnel
+		}
+
+		printTransitions(now, previous, test.tunnels)
+		if printed.String() != test.expected {
+			t.Errorf("%s: printed %q, expected %q", test.name, printed, test.expected)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// Keys of the JSON documents masked by DebugLogger by default.
+//
+var DefaultRedactKeys = regexp.MustCompile(`(?i)password|secret|token|key`)
+
+//
+// Bodies longer than this are truncated by DebugLogger by default.
+//
+const DefaultMaxBodyLength = 4096
+
+const redacted = "[REDACTED]"
+
+//
+// Headers never logged as is, the scheme of Authorization is kept.
+//
+var redactedHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+}
+
+//
+// Request to the REST API, and its response, as given to DebugLogger.Log.
+// The headers and bodies are already redacted and truncated.
+//
+type DebugEntry struct {
+	Method         string
+	URL            string
+	StatusCode     int // Zero if there was no response
+	Status         string
+	Latency        time.Duration
+	RequestHeader  http.Header
+	RequestBody    string
+	ResponseHeader http.Header
+	ResponseBody   string
+	Err            error
+}
+
+func (e *DebugEntry) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %s", e.Method, e.URL)
+	if e.Err != nil {
+		fmt.Fprintf(&b, " failed after %s: %s", e.Latency, e.Err)
+	} else {
+		fmt.Fprintf(&b, " %s in %s", e.Status, e.Latency)
+	}
+
+	var section = func(prefix string, header http.Header, body string) {
+		for _, name := range sortedKeys(header) {
+			for _, value := range header[name] {
+				fmt.Fprintf(&b, "\n%s %s: %s", prefix, name, value)
+			}
+		}
+		if body != "" {
+			fmt.Fprintf(&b, "\n%s %s", prefix, strings.TrimSpace(body))
+		}
+	}
+	section(">", e.RequestHeader, e.RequestBody)
+	section("<", e.ResponseHeader, e.ResponseBody)
+
+	return b.String()
+}
+
+//
+// Logs the requests sent by a Client, set it in Client.Debug. The values of
+// the keys of JSON bodies matching RedactKeys are masked, DefaultRedactKeys
+// if nil, and the bodies are truncated to MaxBodyLength bytes,
+// DefaultMaxBodyLength if zero and unlimited if negative.
+//
+type DebugLogger struct {
+	Log           func(entry *DebugEntry)
+	RedactKeys    *regexp.Regexp
+	MaxBodyLength int
+}
+
+//
+// Log a request, `body` being its body. The body of `resp` is read, and
+// replaced so it can be read again.
+//
+func (d *DebugLogger) log(
+	req *http.Request, body []byte,
+	resp *http.Response, err error,
+	latency time.Duration,
+) {
+	if d.Log == nil {
+		return
+	}
+
+	var entry = DebugEntry{
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		Latency:       latency,
+		RequestHeader: d.redactHeader(req.Header),
+		RequestBody:   d.redactBody(body),
+		Err:           err,
+	}
+
+	if resp != nil {
+		entry.StatusCode = resp.StatusCode
+		entry.Status = resp.Status
+		entry.ResponseHeader = d.redactHeader(resp.Header)
+
+		var data, readErr = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(io.MultiReader(
+			bytes.NewReader(data), &errReader{readErr}))
+		entry.ResponseBody = d.redactBody(data)
+	}
+
+	d.Log(&entry)
+}
+
+//
+// Reader returning `err`, or io.EOF if nil.
+//
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if r.err == nil {
+		return 0, io.EOF
+	}
+	return 0, r.err
+}
+
+func (d *DebugLogger) redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range redactedHeaders {
+		for i, value := range header.Values(name) {
+			// Keep "Basic" or "Bearer", useful to debug authentication
+			var scheme, _, found = strings.Cut(value, " ")
+			if found && name != "Cookie" && name != "Set-Cookie" {
+				header[name][i] = scheme + " " + redacted
+			} else {
+				header[name][i] = redacted
+			}
+		}
+	}
+
+	return header
+}
+
+//
+// Mask the secrets of `body` if it's a JSON document, and truncate it.
+//
+func (d *DebugLogger) redactBody(body []byte) string {
+	var doc interface{}
+	var decoder = json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err == nil {
+		if out, err := json.Marshal(d.redact(doc)); err == nil {
+			body = out
+		}
+	}
+
+	var max = d.MaxBodyLength
+	if max == 0 {
+		max = DefaultMaxBodyLength
+	}
+	if max > 0 && len(body) > max {
+		return fmt.Sprintf("%s... (%d bytes truncated)",
+			body[:max], len(body)-max)
+	}
+
+	return string(body)
+}
+
+func (d *DebugLogger) redact(v interface{}) interface{} {
+	var keys = d.RedactKeys
+	if keys == nil {
+		keys = DefaultRedactKeys
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if value != nil && keys.MatchString(key) {
+				v[key] = redacted
+			} else {
+				v[key] = d.redact(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = d.redact(value)
+		}
+	case string:
+		// Documents embedded in strings, like extra_info
+		if strings.HasPrefix(v, "{") {
+			var doc map[string]interface{}
+			if json.Unmarshal([]byte(v), &doc) == nil {
+				out, _ := json.Marshal(d.redact(doc))
+				return string(out)
+			}
+		}
+	}
+
+	return v
+}
+
+func sortedKeys(header http.Header) (keys []string) {
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
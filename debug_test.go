@@ -0,0 +1,145 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientDebugLogger(t *testing.T) {
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Set-Cookie", "session=s3cr3t")
+			io.WriteString(w, `{"id": "fakeid", "status": "running", "host": "h1"}`)
+		}))
+	defer server.Close()
+
+	var entries []*DebugEntry
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+		Debug: &DebugLogger{
+			Log: func(entry *DebugEntry) {
+				entries = append(entries, entry)
+			},
+		},
+	}
+
+	// The response must still be decoded after being logged
+	info, err := client.Info("fakeid")
+	if err != nil {
+		t.Fatalf("client.Info errored %+v\n", err)
+	}
+	if info.Host != "h1" {
+		t.Errorf("Invalid tunnel info %+v", info)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	var entry = entries[0]
+	if entry.Method != "GET" || entry.URL != server.URL+"/username/tunnels/fakeid" ||
+		entry.StatusCode != 200 || entry.Latency <= 0 {
+		t.Errorf("Invalid entry %+v", entry)
+	}
+	if v := entry.RequestHeader.Get("Authorization"); v != "Basic [REDACTED]" {
+		t.Errorf("Authorization not masked: %s", v)
+	}
+	if v := entry.ResponseHeader.Get("Set-Cookie"); v != "[REDACTED]" {
+		t.Errorf("Set-Cookie not masked: %s", v)
+	}
+	if !strings.Contains(entry.ResponseBody, `"host":"h1"`) {
+		t.Errorf("Invalid response body %s", entry.ResponseBody)
+	}
+	if strings.Contains(entry.String(), "password") {
+		t.Errorf("Credentials leaked: %s", entry)
+	}
+}
+
+func TestClientDebugLoggerConnectionError(t *testing.T) {
+	var server = httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	var entry *DebugEntry
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Debug: &DebugLogger{
+			Log: func(e *DebugEntry) { entry = e },
+		},
+	}
+
+	if _, err := client.Info("fakeid"); err == nil {
+		t.Fatalf("client.Info didn't fail")
+	}
+	if entry == nil || entry.Err == nil || entry.StatusCode != 0 {
+		t.Errorf("Invalid entry %+v", entry)
+	}
+}
+
+func TestDebugLoggerRedactBody(t *testing.T) {
+	var d = DebugLogger{}
+
+	for body, expected := range map[string]string{
+		`{"password": "x", "nested": [{"api_key": "y"}], "id": 1}`: `{"id":1,"nested":[{"api_key":"[REDACTED]"}],"password":"[REDACTED]"}`,
+		`{"extra_info": "{\"token\": \"z\", \"debug\": true}"}`:    `{"extra_info":"{\"debug\":true,\"token\":\"[REDACTED]\"}"}`,
+		`{"access_key": null}`: `{"access_key":null}`,
+		`not json`:             `not json`,
+	} {
+		if out := d.redactBody([]byte(body)); out != expected {
+			t.Errorf("%s redacted as %s, expected %s", body, out, expected)
+		}
+	}
+
+	d.RedactKeys = regexp.MustCompile(`^squid_config$`)
+	var out = d.redactBody([]byte(`{"squid_config": "acl", "password": "x"}`))
+	if out != `{"password":"x","squid_config":"[REDACTED]"}` {
+		t.Errorf("Custom keys not used: %s", out)
+	}
+}
+
+func TestDebugLoggerMaxBodyLength(t *testing.T) {
+	var logs = strings.Repeat("a", DefaultMaxBodyLength+10)
+	var d = DebugLogger{}
+
+	var out = d.redactBody([]byte(logs))
+	if !strings.HasSuffix(out, "... (10 bytes truncated)") ||
+		len(out) != DefaultMaxBodyLength+len("... (10 bytes truncated)") {
+		t.Errorf("Invalid truncation: %s", out[DefaultMaxBodyLength-5:])
+	}
+
+	d.MaxBodyLength = 5
+	if out = d.redactBody([]byte("abcdefgh")); out != "abcde... (3 bytes truncated)" {
+		t.Errorf("Invalid truncation: %s", out)
+	}
+
+	d.MaxBodyLength = -1
+	if out = d.redactBody([]byte(logs)); out != logs {
+		t.Errorf("Body truncated with no limit")
+	}
+}
+
+func TestDebugEntryString(t *testing.T) {
+	var entry = DebugEntry{
+		Method:        "POST",
+		URL:           "http://localhost/username/tunnels",
+		Status:        "200 OK",
+		Latency:       time.Second,
+		RequestHeader: http.Header{"Authorization": {"Basic [REDACTED]"}},
+		RequestBody:   `{"tunnel_identifier":"ci"}`,
+		ResponseBody:  `{"id":"fakeid"}` + "\n",
+	}
+
+	var expected = `POST http://localhost/username/tunnels 200 OK in 1s
+> Authorization: Basic [REDACTED]
+> {"tunnel_identifier":"ci"}
+< {"id":"fakeid"}`
+	if s := entry.String(); s != expected {
+		t.Errorf("Invalid string:\n%s\nexpected:\n%s", s, expected)
+	}
+}
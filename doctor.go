@@ -0,0 +1,338 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//
+// Outcome of a Check.
+//
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+	CheckSkip = "skip"
+)
+
+//
+// Result of one of the connectivity checks of Diagnostics.
+//
+type Check struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"-"`
+	// What was found, or why the check failed.
+	Detail string `json:"detail"`
+}
+
+//
+// Connectivity checks of the REST API of a client, run in stages to tell
+// whether a failure comes from DNS, the proxy, TLS, the credentials or the
+// API itself.
+//
+type Diagnostics struct {
+	Client *Client
+	// Transport whose proxy and TLS settings are checked, the ones of
+	// http.DefaultTransport if nil. It should be the one used by
+	// Client.ExecuteRequest.
+	Transport *http.Transport
+	// Timeout of each check, 10 seconds if zero.
+	Timeout time.Duration
+	// Clock difference with the REST API above which the clock check
+	// warns, 30 seconds if zero.
+	MaxClockSkew time.Duration
+}
+
+func (d *Diagnostics) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return d.Timeout
+}
+
+func (d *Diagnostics) transport() *http.Transport {
+	if d.Transport == nil {
+		return http.DefaultTransport.(*http.Transport)
+	}
+	return d.Transport
+}
+
+//
+// Run the checks in order, calling `report` with each result as it comes,
+// and return all of them.
+//
+func (d *Diagnostics) Run(ctx context.Context, report func(Check)) (
+	checks []Check,
+) {
+	var add = func(check Check) {
+		checks = append(checks, check)
+		if report != nil {
+			report(check)
+		}
+	}
+	var run = func(name string, f func(ctx context.Context) (string, string)) {
+		ctx, cancel := context.WithTimeout(ctx, d.timeout())
+		defer cancel()
+		var start = time.Now()
+		var status, detail = f(ctx)
+		add(Check{
+			Name:     name,
+			Status:   status,
+			Duration: time.Since(start),
+			Detail:   detail,
+		})
+	}
+
+	base, err := url.Parse(d.Client.BaseURL)
+	if err != nil || base.Host == "" {
+		add(Check{Name: "url", Status: CheckFail,
+			Detail: fmt.Sprintf("invalid REST URL %q", d.Client.BaseURL)})
+		return
+	}
+	var address = hostPort(base)
+
+	var proxy *url.URL
+	if d.transport().Proxy != nil {
+		proxy, err = d.transport().Proxy(&http.Request{Method: "GET", URL: base})
+		if err != nil {
+			add(Check{Name: "proxy", Status: CheckFail, Detail: err.Error()})
+			return
+		}
+	}
+
+	// The proxy resolves the names when there is one
+	var unreachable = CheckFail
+	if proxy != nil {
+		unreachable = CheckWarn
+	}
+	var resolved = true
+	run("dns", func(ctx context.Context) (string, string) {
+		addresses, err := net.DefaultResolver.LookupHost(ctx, base.Hostname())
+		if err != nil {
+			resolved = false
+			return unreachable, err.Error()
+		}
+		return CheckPass, base.Hostname() + ": " + strings.Join(addresses, ", ")
+	})
+
+	if resolved {
+		run("tcp direct", func(ctx context.Context) (string, string) {
+			return d.dial(ctx, address, unreachable)
+		})
+	} else {
+		add(Check{Name: "tcp direct", Status: CheckSkip,
+			Detail: "the host couldn't be resolved"})
+	}
+	if proxy != nil {
+		run("tcp proxy", func(ctx context.Context) (string, string) {
+			return d.dial(ctx, hostPort(proxy), CheckFail)
+		})
+	}
+
+	if base.Scheme != "https" {
+		add(Check{Name: "tls", Status: CheckSkip, Detail: "not an https URL"})
+	} else if proxy == nil && !resolved {
+		add(Check{Name: "tls", Status: CheckSkip,
+			Detail: "the host couldn't be resolved"})
+	} else if proxy != nil && proxy.Scheme != "http" {
+		// Only the CONNECT tunnels of the HTTP proxies are supported
+		add(Check{Name: "tls", Status: CheckSkip,
+			Detail: fmt.Sprintf("can't check TLS through a %s proxy",
+				proxy.Scheme)})
+	} else {
+		run("tls", func(ctx context.Context) (string, string) {
+			return d.handshake(ctx, base, address, proxy)
+		})
+	}
+
+	var date time.Time
+	var sent time.Time
+	run("credentials", func(ctx context.Context) (string, string) {
+		if d.Client.Username == "" {
+			return CheckSkip, "no username"
+		}
+		var status, detail string
+		sent = time.Now()
+		status, detail, date = d.credentials(ctx)
+		return status, detail
+	})
+
+	run("versions", func(ctx context.Context) (string, string) {
+		var versionsUrl = d.Client.VersionsURL
+		if versionsUrl == "" {
+			versionsUrl = SauceLabsURL
+		}
+		build, _, err := d.Client.getLastVersion(ctx, versionsUrl)
+		if err != nil {
+			return CheckFail, err.Error()
+		}
+		return CheckPass, fmt.Sprintf("latest build %d", build)
+	})
+
+	if date.IsZero() {
+		add(Check{Name: "clock", Status: CheckSkip,
+			Detail: "no Date header from the REST API"})
+	} else {
+		var maxSkew = d.MaxClockSkew
+		if maxSkew <= 0 {
+			maxSkew = 30 * time.Second
+		}
+		// The Date header is truncated to the second
+		var skew = sent.Sub(date.Add(500 * time.Millisecond)).Round(time.Second)
+		var status = CheckPass
+		if skew > maxSkew || skew < -maxSkew {
+			status = CheckWarn
+		}
+		add(Check{Name: "clock", Status: status,
+			Detail: fmt.Sprintf("local clock off by %s", skew)})
+	}
+
+	return
+}
+
+//
+// Return "host:port" of `u`, with the default port of its scheme.
+//
+func hostPort(u *url.URL) string {
+	var port = u.Port()
+	if port == "" {
+		port = map[string]string{"https": "443", "socks5": "1080"}[u.Scheme]
+		if port == "" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (d *Diagnostics) dial(
+	ctx context.Context, address, failure string,
+) (string, string) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return failure, err.Error()
+	}
+	defer conn.Close()
+	return CheckPass, "connected to " + conn.RemoteAddr().String()
+}
+
+//
+// Open a TCP connection to `address`, through a CONNECT tunnel if `proxy`,
+// an http proxy, isn't nil.
+//
+func (d *Diagnostics) connect(
+	ctx context.Context, address string, proxy *url.URL,
+) (net.Conn, error) {
+	var dialer net.Dialer
+	if proxy == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(proxy))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var req = &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		var password, _ = proxy.User.Password()
+		var credentials = proxy.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The tunnel starts right after a successful answer, without body
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy answered %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func (d *Diagnostics) handshake(
+	ctx context.Context, base *url.URL, address string, proxy *url.URL,
+) (string, string) {
+	conn, err := d.connect(ctx, address, proxy)
+	if err != nil {
+		return CheckFail, err.Error()
+	}
+	defer conn.Close()
+
+	var config = &tls.Config{}
+	if d.transport().TLSClientConfig != nil {
+		config = d.transport().TLSClientConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = base.Hostname()
+	}
+	var client = tls.Client(conn, config)
+	if err := client.HandshakeContext(ctx); err != nil {
+		return CheckFail, err.Error()
+	}
+
+	var state = client.ConnectionState()
+	var cert = state.PeerCertificates[0]
+	return CheckPass, fmt.Sprintf(
+		"%s, subject %s, issuer %s, expires %s, pin sha256/%s",
+		tls.VersionName(state.Version), cert.Subject, cert.Issuer,
+		cert.NotAfter.UTC().Format(time.RFC3339), PublicKeyPin(cert))
+}
+
+//
+// Query the tunnels of the user, and return the Date of the response.
+//
+func (d *Diagnostics) credentials(ctx context.Context) (
+	status string, detail string, date time.Time,
+) {
+	u, err := d.Client.restURL(nil, d.Client.Username, "tunnels")
+	if err != nil {
+		return CheckFail, err.Error(), date
+	}
+	resp, err := d.Client.send(ctx, "GET", u, nil, 1)
+	if err != nil {
+		return CheckFail, err.Error(), date
+	}
+	defer resp.Body.Close()
+	date, _ = http.ParseTime(resp.Header.Get("Date"))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var ids []string
+		if err := d.Client.decode(resp.Body, &ids); err != nil {
+			return CheckFail, err.Error(), date
+		}
+		return CheckPass, fmt.Sprintf("%s has %d tunnels",
+			d.Client.Username, len(ids)), date
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CheckFail, fmt.Sprintf("credentials of %s rejected: %s",
+			d.Client.Username, resp.Status), date
+	}
+	return CheckFail, "REST API answered " + resp.Status, date
+}
@@ -0,0 +1,201 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// REST API answering the tunnel list of "username" with the password
+// "password", and versions.json, with its clock off by `skew`
+func doctorServer(skew time.Duration) *httptest.Server {
+	var server = httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date",
+				time.Now().Add(skew).UTC().Format(http.TimeFormat))
+			switch r.URL.Path {
+			case "/versions.json":
+				io.WriteString(w, versionJson)
+			case "/rest/v1/username/tunnels":
+				if user, password, _ := r.BasicAuth(); user != "username" ||
+					password != "password" {
+					http.Error(w, "Not authorized", 401)
+					return
+				}
+				io.WriteString(w, `["a", "b"]`)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	// Don't log the failed handshakes
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	return server
+}
+
+// Return the statuses of `checks` by name
+func checkStatuses(checks []Check) map[string]string {
+	var statuses = map[string]string{}
+	for _, check := range checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func doctorClient(server *httptest.Server, transport *http.Transport) *Client {
+	return &Client{
+		BaseURL:        server.URL + "/rest/v1",
+		VersionsURL:    server.URL,
+		Username:       "username",
+		Password:       "password",
+		ExecuteRequest: (&http.Client{Transport: transport}).Do,
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	var server = doctorServer(0)
+	defer server.Close()
+
+	var transport = server.Client().Transport.(*http.Transport)
+	var reported []Check
+	var diagnostics = Diagnostics{
+		Client:    doctorClient(server, transport),
+		Transport: transport,
+	}
+	var checks = diagnostics.Run(context.Background(), func(check Check) {
+		reported = append(reported, check)
+	})
+
+	var names []string
+	for _, check := range checks {
+		names = append(names, check.Name)
+		if check.Status != CheckPass {
+			t.Errorf("Check %+v didn't pass", check)
+		}
+	}
+	var expected = "dns, tcp direct, tls, credentials, versions, clock"
+	if strings.Join(names, ", ") != expected {
+		t.Errorf("Invalid checks %v, expected %s", names, expected)
+	}
+	if len(reported) != len(checks) {
+		t.Errorf("Checks not reported: %v", reported)
+	}
+
+	var tls = checks[2].Detail
+	if !strings.HasPrefix(tls, "TLS 1.3, subject O=Acme Co") ||
+		!strings.Contains(tls, "pin sha256/"+PublicKeyPin(server.Certificate())) {
+		t.Errorf("Invalid TLS details %s", tls)
+	}
+	if checks[3].Detail != "username has 2 tunnels" {
+		t.Errorf("Invalid credentials details %s", checks[3].Detail)
+	}
+}
+
+func TestDiagnosticsFailures(t *testing.T) {
+	var server = doctorServer(-time.Hour)
+	defer server.Close()
+
+	var client = doctorClient(server, server.Client().Transport.(*http.Transport))
+	client.Password = "wrong"
+	// The system CAs don't know the test server
+	var diagnostics = Diagnostics{Client: client}
+	var checks = checkStatuses(diagnostics.Run(context.Background(), nil))
+
+	for name, expected := range map[string]string{
+		"dns":         CheckPass,
+		"tcp direct":  CheckPass,
+		"tls":         CheckFail,
+		"credentials": CheckFail,
+		"clock":       CheckWarn,
+	} {
+		if checks[name] != expected {
+			t.Errorf("%s: got %s, expected %s", name, checks[name], expected)
+		}
+	}
+
+	// Nothing listens there
+	client.BaseURL = "http://127.0.0.1:1/rest/v1"
+	checks = checkStatuses(diagnostics.Run(context.Background(), nil))
+	if checks["tcp direct"] != CheckFail || checks["tls"] != CheckSkip ||
+		checks["credentials"] != CheckFail || checks["clock"] != CheckSkip {
+		t.Errorf("Invalid checks %v", checks)
+	}
+}
+
+func TestDiagnosticsProxy(t *testing.T) {
+	var server = doctorServer(0)
+	defer server.Close()
+	var proxy = newConnectProxy("user", "password")
+	defer proxy.Close()
+
+	var proxyUrl, _ = url.Parse(proxy.URL)
+	proxyUrl.User = url.UserPassword("user", "password")
+	var transport = server.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyUrl)
+
+	var diagnostics = Diagnostics{
+		Client:    doctorClient(server, transport),
+		Transport: transport,
+	}
+	var checks = diagnostics.Run(context.Background(), nil)
+	for _, check := range checks {
+		if check.Status != CheckPass {
+			t.Errorf("Check %+v didn't pass", check)
+		}
+	}
+	if statuses := checkStatuses(checks); statuses["tcp proxy"] != CheckPass {
+		t.Errorf("The proxy wasn't checked: %v", statuses)
+	}
+	// The TLS check, and the requests sharing a connection
+	if targets := proxy.Targets(); len(targets) != 2 {
+		t.Errorf("Invalid tunnels %v", targets)
+	}
+}
+
+// The TLS check can't go through the proxies without CONNECT
+func TestDiagnosticsSOCKSProxy(t *testing.T) {
+	var server = doctorServer(0)
+	defer server.Close()
+	// Hang up on everything
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen errored %+v\n", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	var proxyUrl = &url.URL{Scheme: "socks5", Host: listener.Addr().String()}
+	var transport = server.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyUrl)
+
+	var diagnostics = Diagnostics{
+		Client:    doctorClient(server, transport),
+		Transport: transport,
+	}
+	var checks = diagnostics.Run(context.Background(), nil)
+	for _, check := range checks {
+		if check.Name == "tls" && (check.Status != CheckSkip ||
+			!strings.Contains(check.Detail, "socks5 proxy")) {
+			t.Errorf("Invalid check %+v", check)
+		}
+	}
+	if statuses := checkStatuses(checks); statuses["tcp proxy"] != CheckPass ||
+		statuses["tls"] != CheckSkip {
+		t.Errorf("Invalid checks %v", statuses)
+	}
+}
@@ -0,0 +1,14 @@
+//
+// Hooks letting the tests of the commands reach into the rest package,
+// without adding settings to its API.
+//
+package hooks
+
+import "time"
+
+//
+// Set the time between two status requests and two heartbeats of the tunnels
+// created by `client`, a *rest.Client, instead of the defaults. Set by the
+// rest package.
+//
+var SetLoopIntervals func(client interface{}, status, heartbeat time.Duration)
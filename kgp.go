@@ -0,0 +1,177 @@
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+)
+
+//
+// Port of the KGP server of the tunnels created without Request.KGPPort.
+//
+const DefaultKGPPort = 443
+
+//
+// Result of the connection to the KGP server of a tunnel by one of its
+// addresses, by Tunnel.ProbeKGP.
+//
+type KGPProbe struct {
+	// "host" for the DNS name of the tunnel VM, "ip" for its IP address.
+	Target  string
+	Address string
+	// Addresses the DNS name resolved to, empty for the IP address.
+	Resolved []string
+	// Time spent resolving the name, connecting, and in the TLS handshake
+	// if one was done.
+	Lookup    time.Duration
+	Connect   time.Duration
+	Handshake time.Duration
+	// Subject of the certificate of the server, if a TLS handshake was done.
+	Certificate string
+	Err         error
+}
+
+//
+// Whether the KGP server could be reached.
+//
+func (p *KGPProbe) OK() bool {
+	return p.Err == nil
+}
+
+//
+// Address of the KGP server of a tunnel, on the VM of the tunnel.
+//
+type KGPEndpoint struct {
+	Host string `json:"host"`
+	Ip   string `json:"ip"`
+}
+
+func (t *Tunnel) kgpPort() int {
+	if t.KGPPort == 0 {
+		return DefaultKGPPort
+	}
+	return t.KGPPort
+}
+
+//
+// Check that the KGP server of the tunnel can be reached on its port, with
+// both its DNS name and its IP address, to catch the firewalls in the way
+// before Sauce Connect starts. The host and the IP address of the tunnel
+// are queried first if they aren't known.
+//
+// The error only tells if the tunnel couldn't be queried, the outcome of
+// each connection is in its KGPProbe.
+//
+func (t *Tunnel) ProbeKGP(ctx context.Context) ([]KGPProbe, error) {
+	return t.probeKGP(ctx, nil)
+}
+
+//
+// Like ProbeKGP, also doing a TLS handshake with `config` once connected.
+// The DNS name of the tunnel, or its IP address without one, is the server
+// name if config doesn't set one.
+//
+func (t *Tunnel) ProbeKGPTLS(ctx context.Context, config *tls.Config) (
+	[]KGPProbe, error,
+) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return t.probeKGP(ctx, config)
+}
+
+func (t *Tunnel) probeKGP(ctx context.Context, config *tls.Config) (
+	probes []KGPProbe, err error,
+) {
+	ctx, span := t.Client.startSpan(ctx, "Tunnel.ProbeKGP",
+		Attr("tunnel", t.ID()), Attr("port", t.kgpPort()),
+		Attr("tls", config != nil))
+	defer func() { span.End(err) }()
+
+	var endpoint = t.KGPEndpoint()
+	if endpoint.Host == "" && endpoint.Ip == "" {
+		status, err := t.Client.status(ctx, t.ID())
+		if err != nil {
+			return nil, err
+		}
+		endpoint = KGPEndpoint{Host: status.Host, Ip: status.Ip}
+		t.updateKGPEndpoint(endpoint)
+	}
+
+	// The DNS name of the tunnel, or its IP address without one, is the
+	// server name of the TLS handshakes
+	var serverName = endpoint.Host
+	if serverName == "" {
+		serverName = endpoint.Ip
+	}
+	var port = strconv.Itoa(t.kgpPort())
+	if endpoint.Host != "" {
+		var probe = KGPProbe{
+			Target:  "host",
+			Address: net.JoinHostPort(endpoint.Host, port),
+		}
+		var start = time.Now()
+		probe.Resolved, probe.Err = net.DefaultResolver.LookupHost(ctx,
+			endpoint.Host)
+		probe.Lookup = time.Since(start)
+		if probe.Err == nil {
+			probeAddress(ctx, &probe, config, serverName)
+		}
+		probes = append(probes, probe)
+	}
+	if endpoint.Ip != "" {
+		var probe = KGPProbe{
+			Target:  "ip",
+			Address: net.JoinHostPort(endpoint.Ip, port),
+		}
+		probeAddress(ctx, &probe, config, serverName)
+		probes = append(probes, probe)
+	}
+
+	for _, probe := range probes {
+		span.AddEvent("probe", Attr("target", probe.Target),
+			Attr("address", probe.Address), Attr("ok", probe.OK()))
+		t.log().Debug("KGP server probed", "target", probe.Target,
+			"address", probe.Address, "connect", probe.Connect,
+			"handshake", probe.Handshake, "error", probe.Err)
+	}
+	return probes, nil
+}
+
+//
+// Connect to the address of `probe`, and do a TLS handshake with `config`
+// if it isn't nil, with `serverName` if config doesn't set one.
+//
+func probeAddress(
+	ctx context.Context, probe *KGPProbe, config *tls.Config, serverName string,
+) {
+	var dialer net.Dialer
+	var start = time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", probe.Address)
+	probe.Connect = time.Since(start)
+	if err != nil {
+		probe.Err = err
+		return
+	}
+	defer conn.Close()
+
+	if config == nil {
+		return
+	}
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	var client = tls.Client(conn, config)
+	start = time.Now()
+	probe.Err = client.HandshakeContext(ctx)
+	probe.Handshake = time.Since(start)
+	if probe.Err == nil {
+		var certificates = client.ConnectionState().PeerCertificates
+		if len(certificates) > 0 {
+			probe.Certificate = certificates[0].Subject.String()
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Port of the address of `listener`
+func listenerPort(listener net.Listener) int {
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestTunnelProbeKGP(t *testing.T) {
+	var server = httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	var tunnel = Tunnel{
+		Client:  &Client{},
+		Id:      "fakeid",
+		Host:    "localhost",
+		Ip:      "127.0.0.1",
+		KGPPort: listenerPort(server.Listener),
+	}
+	probes, err := tunnel.ProbeKGP(context.Background())
+	if err != nil {
+		t.Fatalf("tunnel.ProbeKGP errored %+v\n", err)
+	}
+	if len(probes) != 2 || probes[0].Target != "host" || probes[1].Target != "ip" {
+		t.Fatalf("Invalid probes %+v", probes)
+	}
+	for _, probe := range probes {
+		if !probe.OK() || probe.Connect == 0 || probe.Handshake != 0 {
+			t.Errorf("Invalid probe %+v", probe)
+		}
+	}
+	if len(probes[0].Resolved) == 0 || probes[1].Resolved != nil {
+		t.Errorf("Invalid resolved addresses %+v", probes)
+	}
+	if probes[1].Address != "127.0.0.1:"+strconv.Itoa(tunnel.KGPPort) {
+		t.Errorf("Invalid address %s", probes[1].Address)
+	}
+
+	// Nothing listens there anymore
+	server.Close()
+	probes, _ = tunnel.ProbeKGP(context.Background())
+	for _, probe := range probes {
+		if probe.OK() {
+			t.Errorf("Probe %+v didn't fail", probe)
+		}
+	}
+
+	// The DNS name doesn't resolve
+	tunnel.Host = "kgp.invalid"
+	probes, _ = tunnel.ProbeKGP(context.Background())
+	if probes[0].OK() || probes[0].Connect != 0 {
+		t.Errorf("Invalid probe %+v", probes[0])
+	}
+}
+
+func TestTunnelProbeKGPTLS(t *testing.T) {
+	var server = httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	var roots = x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	var tunnel = Tunnel{
+		Client:  &Client{},
+		Id:      "fakeid",
+		Ip:      "127.0.0.1",
+		KGPPort: listenerPort(server.Listener),
+	}
+
+	probes, err := tunnel.ProbeKGPTLS(context.Background(),
+		&tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("tunnel.ProbeKGPTLS errored %+v\n", err)
+	}
+	if len(probes) != 1 || !probes[0].OK() || probes[0].Handshake == 0 ||
+		probes[0].Certificate != "O=Acme Co" {
+		t.Errorf("Invalid probes %+v", probes)
+	}
+
+	// The certificate isn't trusted
+	probes, _ = tunnel.ProbeKGPTLS(context.Background(), nil)
+	if len(probes) != 1 || probes[0].OK() || probes[0].Connect == 0 {
+		t.Errorf("Invalid probes %+v", probes)
+	}
+}
+
+// The host of the tunnel is queried when unknown
+func TestTunnelProbeKGPStatus(t *testing.T) {
+	var kgp = httptest.NewServer(http.NotFoundHandler())
+	defer kgp.Close()
+	var server = multiResponseServer([]R{
+		stringResponse(`{"status": "running", "host": "", "ip_address": "127.0.0.1"}`),
+	})
+	defer server.Close()
+
+	var tunnel = Tunnel{
+		Client:  &Client{BaseURL: server.URL, Username: "username"},
+		Id:      "fakeid",
+		KGPPort: listenerPort(kgp.Listener),
+	}
+	probes, err := tunnel.ProbeKGP(context.Background())
+	if err != nil {
+		t.Fatalf("tunnel.ProbeKGP errored %+v\n", err)
+	}
+	if tunnel.KGPEndpoint().Ip != "127.0.0.1" || len(probes) != 1 ||
+		!probes[0].OK() {
+		t.Errorf("Invalid probes %+v", probes)
+	}
+
+	server.Close()
+	if _, err := tunnel.Client.Tunnel("fakeid").ProbeKGP(
+		context.Background()); err == nil {
+		t.Errorf("tunnel.ProbeKGP didn't error")
+	}
+}
+
+// The status loop follows the tunnel VM
+func TestTunnelLoopKGPChanges(t *testing.T) {
+	var moved = `{"status": "running", "host": "OTHERHOST", "ip_address": "5.6.7.8"}`
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		stringResponse(statusRunningJSON),
+		stringResponse(statusRunningJSON),
+		stringResponse(moved),
+		stringResponse(moved),
+		stringResponse(statusShutdownJSON),
+	})
+	defer server.Close()
+
+	tunnel, err := createTunnel(server.URL)
+	if err != nil {
+		t.Fatalf("client.createWithTimeout errored %+v\n", err)
+	}
+	go tunnel.serverStatusLoop(time.Millisecond)
+
+	var expected = KGPEndpoint{Host: "OTHERHOST", Ip: "5.6.7.8"}
+	if endpoint := <-tunnel.KGPChanges; endpoint != expected {
+		t.Errorf("Invalid change %+v", endpoint)
+	}
+	<-tunnel.ServerStatus
+	if endpoint := tunnel.KGPEndpoint(); endpoint != expected {
+		t.Errorf("Invalid endpoint %+v", endpoint)
+	}
+	if tunnel.Host != "HOSTNAME" || tunnel.Ip != "1.2.3.4" {
+		t.Errorf("Initial address changed: %s %s", tunnel.Host, tunnel.Ip)
+	}
+	select {
+	case endpoint := <-tunnel.KGPChanges:
+		t.Errorf("Unexpected change %+v", endpoint)
+	default:
+	}
+}
+
+// Only the latest change is kept until received
+func TestTunnelUpdateKGPEndpoint(t *testing.T) {
+	var tunnel = Tunnel{
+		Client:     &Client{},
+		Id:         "fakeid",
+		KGPChanges: make(chan KGPEndpoint, 1),
+		shared: &tunnelState{
+			endpoint: KGPEndpoint{Host: "a", Ip: "1.1.1.1"},
+		},
+	}
+	tunnel.updateKGPEndpoint(KGPEndpoint{Host: "b", Ip: "2.2.2.2"})
+	tunnel.updateKGPEndpoint(KGPEndpoint{})
+	tunnel.updateKGPEndpoint(KGPEndpoint{Ip: "3.3.3.3"})
+
+	if endpoint := <-tunnel.KGPChanges; endpoint != (KGPEndpoint{Ip: "3.3.3.3"}) {
+		t.Errorf("Invalid change %+v", endpoint)
+	}
+	if endpoint := tunnel.KGPEndpoint(); endpoint.Ip != "3.3.3.3" {
+		t.Errorf("Invalid endpoint %+v", endpoint)
+	}
+}
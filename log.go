@@ -0,0 +1,30 @@
+package rest
+
+import (
+	"log/slog"
+)
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+//
+// Return the logger of the client, one discarding everything if unset.
+//
+func (c *Client) log() *slog.Logger {
+	if c.Logger == nil {
+		return discardLogger
+	}
+
+	return c.Logger
+}
+
+//
+// Return the logger of the tunnel, the one of its client with the tunnel id
+// if unset.
+//
+func (t *Tunnel) log() *slog.Logger {
+	if t.Logger != nil {
+		return t.Logger
+	}
+
+	return t.Client.log().With("tunnel", t.ID())
+}
@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slog.Handler keeping the records, with their attributes flattened in a map
+// along with "level" and "msg".
+type recordHandler struct {
+	mutex   *sync.Mutex
+	records *[]map[string]interface{}
+	attrs   []slog.Attr
+}
+
+func newRecordLogger() (*slog.Logger, *recordHandler) {
+	var h = &recordHandler{
+		mutex:   &sync.Mutex{},
+		records: &[]map[string]interface{}{},
+	}
+	return slog.New(h), h
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	var record = map[string]interface{}{
+		"level": r.Level.String(),
+		"msg":   r.Message,
+	}
+	for _, attr := range h.attrs {
+		record[attr.Key] = attr.Value.Any()
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		record[attr.Key] = attr.Value.Any()
+		return true
+	})
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	*h.records = append(*h.records, record)
+
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var c = *h
+	c.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &c
+}
+
+func (h *recordHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Return the records with the message `msg`
+func (h *recordHandler) find(msg string) (found []map[string]interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, record := range *h.records {
+		if record["msg"] == msg {
+			found = append(found, record)
+		}
+	}
+	return
+}
+
+func TestClientLoggerCreate(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		stringResponse(statusRunningJSON),
+	})
+	defer server.Close()
+
+	var logger, records = newRecordLogger()
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+		Logger:   logger,
+	}
+	tunnel, err := client.CreateTunnelWithTimeout(&Request{}, time.Second)
+	if err != nil {
+		t.Fatalf("client.CreateWithTimeout errored %+v\n", err)
+	}
+
+	var requests = records.find("REST request")
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests logged, got %+v", requests)
+	}
+	var create = requests[0]
+	if create["level"] != "DEBUG" || create["method"] != "POST" ||
+		create["path"] != "/username/tunnels" || create["status"] != int64(200) ||
+		create["attempt"] != int64(1) {
+		t.Errorf("Invalid record %+v", create)
+	}
+	if _, ok := create["duration"].(time.Duration); !ok {
+		t.Errorf("No duration in %+v", create)
+	}
+
+	var created = records.find("Tunnel created")
+	if len(created) != 1 || created[0]["tunnel"] != tunnel.Id {
+		t.Errorf("Invalid records %+v", created)
+	}
+	var changes = records.find("Tunnel status changed")
+	if len(changes) != 1 ||
+		changes[0]["from"] != "new" || changes[0]["to"] != "running" {
+		t.Errorf("Invalid records %+v", changes)
+	}
+}
+
+func TestClientLoggerHTTPError(t *testing.T) {
+	var server = multiResponseServer([]R{
+		errorResponse(500, "oops"),
+	})
+	defer server.Close()
+
+	var logger, records = newRecordLogger()
+	var client = Client{BaseURL: server.URL, Username: "username", Logger: logger}
+	client.Info("fakeid")
+
+	var requests = records.find("REST request")
+	if len(requests) != 1 ||
+		requests[0]["level"] != "DEBUG" || requests[0]["status"] != int64(500) {
+		t.Errorf("Invalid records %+v", requests)
+	}
+}
+
+// The requests logged by Client.Debug aren't logged twice
+func TestClientLoggerDebug(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(statusRunningJSON),
+		errorResponse(500, "oops"),
+	})
+	defer server.Close()
+
+	var logger, records = newRecordLogger()
+	var entries = 0
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Logger:   logger,
+		Debug: &DebugLogger{
+			Log: func(*DebugEntry) { entries += 1 },
+		},
+	}
+	client.Info("fakeid")
+	client.Info("fakeid")
+
+	var requests = records.find("REST request")
+	if entries != 2 || len(requests) != 1 || requests[0]["status"] != int64(500) {
+		t.Errorf("Invalid records %+v, %d entries", requests, entries)
+	}
+}
+
+func TestTunnelLoggerLoops(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		stringResponse(statusRunningJSON),
+		errorResponse(500, "oops"),
+		stringResponse(statusShutdownJSON),
+	})
+	defer server.Close()
+
+	var logger, records = newRecordLogger()
+	var client = Client{BaseURL: server.URL, Username: "username", Logger: logger}
+	tunnel, err := client.CreateTunnelWithTimeout(&Request{}, time.Second)
+	if err != nil {
+		t.Fatalf("client.CreateWithTimeout errored %+v\n", err)
+	}
+
+	go tunnel.serverStatusLoop(time.Millisecond)
+	<-tunnel.ServerStatus
+
+	var failures = records.find("Unable to query tunnel status")
+	if len(failures) != 1 || failures[0]["tunnel"] != tunnel.Id ||
+		failures[0]["level"] != "WARN" {
+		t.Errorf("Invalid records %+v", failures)
+	}
+	var changes = records.find("Tunnel status changed")
+	if len(changes) != 2 ||
+		changes[1]["from"] != "running" || changes[1]["to"] != "shutdown" {
+		t.Errorf("Invalid records %+v", changes)
+	}
+
+	// Failed heartbeats are logged, not returned
+	server.Close()
+	tunnel.heartbeat(true, time.Now())
+	var heartbeats = records.find("Heartbeat failed")
+	if len(heartbeats) != 1 || heartbeats[0]["kgp_connected"] != true {
+		t.Errorf("Invalid records %+v", heartbeats)
+	}
+}
+
+func TestTunnelLogger(t *testing.T) {
+	var logger, records = newRecordLogger()
+	var tunnel = Tunnel{Client: &Client{}, Id: "fakeid", Logger: logger}
+
+	tunnel.log().Info("hello")
+	var found = records.find("hello")
+	if len(found) != 1 || found[0]["tunnel"] != nil {
+		t.Errorf("Tunnel.Logger not used as is: %+v", found)
+	}
+
+	// Falls back to the logger of the client
+	tunnel.Logger = nil
+	tunnel.Client.Logger = logger
+	tunnel.log().Info("hello")
+	found = records.find("hello")
+	if len(found) != 2 || found[1]["tunnel"] != "fakeid" {
+		t.Errorf("Invalid records %+v", found)
+	}
+}
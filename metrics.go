@@ -0,0 +1,327 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Receives the measures of a Client and of its tunnels, set it in
+// Client.Metrics. PrometheusMetrics implements it.
+//
+type Metrics interface {
+	// A request to `endpoint`, "GET /{user}/tunnels/{id}" for instance,
+	// finished with `status`, zero if the REST API couldn't be reached.
+	Request(endpoint string, status int, duration time.Duration)
+	// The request to `endpoint` is about to be sent again.
+	Retry(endpoint string)
+	// A heartbeat of `tunnel` was sent, `err` is nil if it succeeded.
+	Heartbeat(tunnel string, err error)
+	// The REST API reported `status` for `tunnel`.
+	TunnelStatus(tunnel, status string)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) Request(string, int, time.Duration) {}
+func (noMetrics) Retry(string)                       {}
+func (noMetrics) Heartbeat(string, error)            {}
+func (noMetrics) TunnelStatus(string, string)        {}
+
+func (c *Client) metrics() Metrics {
+	if c.Metrics == nil {
+		return noMetrics{}
+	}
+
+	return c.Metrics
+}
+
+//
+// Return the endpoint of `u` for the metrics: the method and the path
+// relative to BaseURL, with the usernames and the tunnel ids replaced by
+// placeholders to keep the number of series bounded.
+//
+func (c *Client) endpoint(method string, u *url.URL) string {
+	// Only the paths of the REST API are known
+	base, err := url.Parse(c.BaseURL)
+	if err != nil || u.Host != base.Host {
+		return method + " " + u.Path
+	}
+	var path = strings.TrimPrefix(u.EscapedPath(),
+		strings.TrimSuffix(base.EscapedPath(), "/"))
+
+	var segments = strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if i == 0 && segment != "users" && segment != "versions.json" {
+			segments[i] = "{user}"
+		} else if i > 0 && segments[i-1] == "users" {
+			segments[i] = "{user}"
+		} else if i > 0 && segments[i-1] == "tunnels" {
+			segments[i] = "{id}"
+		}
+	}
+
+	return method + " /" + strings.Join(segments, "/")
+}
+
+//
+// Upper bounds of the request latency histogram buckets, in seconds.
+//
+var DefaultBuckets = []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+//
+// Metrics kept in memory, and served in the Prometheus text format by
+// ServeHTTP. The zero value is ready to use. The series of a tunnel are
+// removed once the REST API reports it terminated.
+//
+type PrometheusMetrics struct {
+	// Latency histogram buckets, DefaultBuckets if nil. They are copied when
+	// the metrics are first used, later changes are ignored.
+	Buckets []float64
+
+	mutex      sync.Mutex
+	bounds     []float64
+	requests   map[requestKey]*histogram
+	retries    map[string]uint64
+	heartbeats map[heartbeatKey]uint64
+	// Time of the last successful heartbeat of every tunnel, or of the first
+	// failed one if none succeeded yet.
+	lastHeartbeats map[string]time.Time
+	statuses       map[string]string
+}
+
+type requestKey struct {
+	endpoint string
+	status   int
+}
+
+type heartbeatKey struct {
+	tunnel string
+	result string
+}
+
+type histogram struct {
+	counts []uint64 // One per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (m *PrometheusMetrics) Request(
+	endpoint string, status int, duration time.Duration,
+) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.requests == nil {
+		m.requests = map[requestKey]*histogram{}
+	}
+	var buckets = m.buckets()
+	var key = requestKey{endpoint, status}
+	var h = m.requests[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(buckets))}
+		m.requests[key] = h
+	}
+
+	var seconds = duration.Seconds()
+	for i, bound := range buckets {
+		if seconds <= bound {
+			h.counts[i] += 1
+			break
+		}
+	}
+	h.count += 1
+	h.sum += seconds
+}
+
+func (m *PrometheusMetrics) Retry(endpoint string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.retries == nil {
+		m.retries = map[string]uint64{}
+	}
+	m.retries[endpoint] += 1
+}
+
+func (m *PrometheusMetrics) Heartbeat(tunnel string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.heartbeats == nil {
+		m.heartbeats = map[heartbeatKey]uint64{}
+		m.lastHeartbeats = map[string]time.Time{}
+	}
+
+	var result = "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.heartbeats[heartbeatKey{tunnel, result}] += 1
+
+	if _, ok := m.lastHeartbeats[tunnel]; !ok || err == nil {
+		m.lastHeartbeats[tunnel] = time.Now()
+	}
+}
+
+func (m *PrometheusMetrics) TunnelStatus(tunnel, status string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Only the running tunnels are reported, the series of the others would
+	// pile up
+	if status != "running" {
+		delete(m.statuses, tunnel)
+		delete(m.lastHeartbeats, tunnel)
+		delete(m.heartbeats, heartbeatKey{tunnel, "success"})
+		delete(m.heartbeats, heartbeatKey{tunnel, "failure"})
+		return
+	}
+
+	if m.statuses == nil {
+		m.statuses = map[string]string{}
+	}
+	m.statuses[tunnel] = status
+}
+
+// The caller holds the mutex
+func (m *PrometheusMetrics) buckets() []float64 {
+	if m.bounds == nil {
+		var buckets = m.Buckets
+		if buckets == nil {
+			buckets = DefaultBuckets
+		}
+		m.bounds = make([]float64, len(buckets))
+		copy(m.bounds, buckets)
+	}
+
+	return m.bounds
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+//
+// Write the metrics to `w` in the Prometheus text format.
+//
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var b strings.Builder
+	var header = func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	var keys []requestKey
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	header("sauceproxy_rest_requests_total", "counter",
+		"Requests to the REST API, status is 0 for connection errors.")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "sauceproxy_rest_requests_total{endpoint=%s,status=\"%d\"} %d\n",
+			quote(key.endpoint), key.status, m.requests[key].count)
+	}
+
+	header("sauceproxy_rest_request_duration_seconds", "histogram",
+		"Latency of the requests to the REST API.")
+	for _, key := range keys {
+		var h = m.requests[key]
+		var labels = fmt.Sprintf("endpoint=%s,status=\"%d\"",
+			quote(key.endpoint), key.status)
+		var cumulative uint64
+		for i, bound := range m.buckets() {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b,
+				"sauceproxy_rest_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n",
+				labels, bound, cumulative)
+		}
+		fmt.Fprintf(&b,
+			"sauceproxy_rest_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n",
+			labels, h.count)
+		fmt.Fprintf(&b, "sauceproxy_rest_request_duration_seconds_sum{%s} %g\n",
+			labels, h.sum)
+		fmt.Fprintf(&b, "sauceproxy_rest_request_duration_seconds_count{%s} %d\n",
+			labels, h.count)
+	}
+
+	header("sauceproxy_rest_retries_total", "counter",
+		"Requests to the REST API sent again.")
+	var endpoints []string
+	for endpoint := range m.retries {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(&b, "sauceproxy_rest_retries_total{endpoint=%s} %d\n",
+			quote(endpoint), m.retries[endpoint])
+	}
+
+	header("sauceproxy_heartbeats_total", "counter",
+		"Heartbeats sent, by result: success or failure.")
+	var heartbeats []heartbeatKey
+	for key := range m.heartbeats {
+		heartbeats = append(heartbeats, key)
+	}
+	sort.Slice(heartbeats, func(i, j int) bool {
+		if heartbeats[i].tunnel != heartbeats[j].tunnel {
+			return heartbeats[i].tunnel < heartbeats[j].tunnel
+		}
+		return heartbeats[i].result < heartbeats[j].result
+	})
+	for _, key := range heartbeats {
+		fmt.Fprintf(&b, "sauceproxy_heartbeats_total{tunnel=%s,result=%s} %d\n",
+			quote(key.tunnel), quote(key.result), m.heartbeats[key])
+	}
+
+	header("sauceproxy_heartbeat_age_seconds", "gauge",
+		"Time since the last successful heartbeat.")
+	var tunnels []string
+	for tunnel := range m.lastHeartbeats {
+		tunnels = append(tunnels, tunnel)
+	}
+	sort.Strings(tunnels)
+	for _, tunnel := range tunnels {
+		fmt.Fprintf(&b, "sauceproxy_heartbeat_age_seconds{tunnel=%s} %g\n",
+			quote(tunnel), time.Since(m.lastHeartbeats[tunnel]).Seconds())
+	}
+
+	header("sauceproxy_tunnel_status", "gauge",
+		"Status of the running tunnels, the value is always 1.")
+	tunnels = nil
+	for tunnel := range m.statuses {
+		tunnels = append(tunnels, tunnel)
+	}
+	sort.Strings(tunnels)
+	for _, tunnel := range tunnels {
+		fmt.Fprintf(&b, "sauceproxy_tunnel_status{tunnel=%s,status=%s} 1\n",
+			quote(tunnel), quote(m.statuses[tunnel]))
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+//
+// Quote a label value, escaping backslashes, quotes and newlines.
+//
+func quote(value string) string {
+	var r = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(value) + `"`
+}
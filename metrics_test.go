@@ -0,0 +1,249 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientEndpoint(t *testing.T) {
+	var client = Client{BaseURL: "https://saucelabs.com/rest/v1"}
+
+	for u, expected := range map[string]string{
+		"https://saucelabs.com/rest/v1/john/tunnels":                   "GET /{user}/tunnels",
+		"https://saucelabs.com/rest/v1/john/tunnels?full=1":            "GET /{user}/tunnels",
+		"https://saucelabs.com/rest/v1/john/tunnels/abc":               "GET /{user}/tunnels/{id}",
+		"https://saucelabs.com/rest/v1/john/tunnels/abc/connected":     "GET /{user}/tunnels/{id}/connected",
+		"https://saucelabs.com/rest/v1/john/errors":                    "GET /{user}/errors",
+		"https://saucelabs.com/rest/v1/users/john/list-subaccounts":    "GET /users/{user}/list-subaccounts",
+		"https://saucelabs.com/versions.json":                          "GET /versions.json",
+		"https://other.example.com/rest/v1/john/tunnels/abc/connected": "GET /rest/v1/john/tunnels/abc/connected",
+	} {
+		parsed, _ := url.Parse(u)
+		if endpoint := client.endpoint("GET", parsed); endpoint != expected {
+			t.Errorf("%s: got endpoint %s, expected %s", u, endpoint, expected)
+		}
+	}
+}
+
+func TestPrometheusMetricsClient(t *testing.T) {
+	var attempts = 0
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/connected"):
+				attempts += 1
+				if attempts == 1 {
+					http.Error(w, "oops", 500)
+					return
+				}
+				io.WriteString(w, `{"result": true}`)
+			case r.Method == "POST":
+				io.WriteString(w, createJSON)
+			default:
+				io.WriteString(w, statusRunningJSON)
+			}
+		}))
+	defer server.Close()
+
+	var metrics = &PrometheusMetrics{}
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+		Metrics:  metrics,
+	}
+	tunnel, err := client.CreateTunnelWithTimeout(&Request{}, time.Second)
+	if err != nil {
+		t.Fatalf("client.CreateWithTimeout errored %+v\n", err)
+	}
+	tunnel.heartbeat(true, time.Now())
+	tunnel.heartbeat(true, time.Now())
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	var out = b.String()
+
+	for _, line := range []string{
+		`sauceproxy_rest_requests_total{endpoint="POST /{user}/tunnels",status="200"} 1`,
+		`sauceproxy_rest_requests_total{endpoint="GET /{user}/tunnels/{id}",status="200"} 1`,
+		`sauceproxy_rest_requests_total{endpoint="POST /{user}/tunnels/{id}/connected",status="500"} 1`,
+		`sauceproxy_rest_request_duration_seconds_count{endpoint="POST /{user}/tunnels",status="200"} 1`,
+		`sauceproxy_heartbeats_total{tunnel="49958ce5ec9f49c796542e0c691455a6",result="failure"} 1`,
+		`sauceproxy_heartbeats_total{tunnel="49958ce5ec9f49c796542e0c691455a6",result="success"} 1`,
+		`sauceproxy_heartbeat_age_seconds{tunnel="49958ce5ec9f49c796542e0c691455a6"}`,
+		`sauceproxy_tunnel_status{tunnel="49958ce5ec9f49c796542e0c691455a6",status="running"} 1`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Missing line %s in:\n%s", line, out)
+		}
+	}
+}
+
+// The requests rejected by the circuit breaker aren't counted
+func TestPrometheusMetricsCircuitOpen(t *testing.T) {
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "oops", 500)
+		}))
+	defer server.Close()
+
+	var metrics = &PrometheusMetrics{}
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Metrics:  metrics,
+		Breaker:  &CircuitBreaker{Threshold: 1, Cooldown: time.Minute},
+	}
+	for i := 0; i < 3; i++ {
+		client.Status("fakeid")
+	}
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	var line = `sauceproxy_rest_requests_total{endpoint="GET /{user}/tunnels/{id}",status="500"} 1`
+	if !strings.Contains(b.String(), line) {
+		t.Errorf("Missing line %s in:\n%s", line, b.String())
+	}
+	if strings.Contains(b.String(), `status="0"`) {
+		t.Errorf("Requests rejected by the breaker counted in:\n%s", b.String())
+	}
+}
+
+func TestPrometheusMetricsRetry(t *testing.T) {
+	var expected = "Bearer two"
+	var seen []string
+	var server = authServer(&expected, &seen)
+	defer server.Close()
+
+	var token = "one"
+	var metrics = &PrometheusMetrics{}
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Metrics:  metrics,
+		Auth: &CredentialProvider{
+			Fetch: func() (Credentials, error) {
+				var c = Credentials{Token: token}
+				token = "two"
+				return c, nil
+			},
+		},
+	}
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Fatalf("client.Status errored %+v\n", err)
+	}
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	for _, line := range []string{
+		`sauceproxy_rest_retries_total{endpoint="GET /{user}/tunnels/{id}"} 1`,
+		`sauceproxy_rest_requests_total{endpoint="GET /{user}/tunnels/{id}",status="401"} 1`,
+		`sauceproxy_rest_requests_total{endpoint="GET /{user}/tunnels/{id}",status="200"} 1`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Missing line %s in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestPrometheusMetricsHistogram(t *testing.T) {
+	var metrics = PrometheusMetrics{Buckets: []float64{0.1, 1}}
+	metrics.Request("GET /x", 200, 50*time.Millisecond)
+	metrics.Request("GET /x", 200, 500*time.Millisecond)
+	metrics.Request("GET /x", 200, 5*time.Second)
+	metrics.Request("GET /x", 0, time.Second)
+	// The buckets are fixed once used
+	metrics.Buckets[0] = 10
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	for _, line := range []string{
+		`sauceproxy_rest_request_duration_seconds_bucket{endpoint="GET /x",status="200",le="0.1"} 1`,
+		`sauceproxy_rest_request_duration_seconds_bucket{endpoint="GET /x",status="200",le="1"} 2`,
+		`sauceproxy_rest_request_duration_seconds_bucket{endpoint="GET /x",status="200",le="+Inf"} 3`,
+		`sauceproxy_rest_request_duration_seconds_sum{endpoint="GET /x",status="200"} 5.55`,
+		`sauceproxy_rest_request_duration_seconds_bucket{endpoint="GET /x",status="0",le="1"} 1`,
+		`sauceproxy_rest_requests_total{endpoint="GET /x",status="0"} 1`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Missing line %s in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestPrometheusMetricsHeartbeatAge(t *testing.T) {
+	var metrics = PrometheusMetrics{}
+	metrics.Heartbeat("a", nil)
+	var first = metrics.lastHeartbeats["a"]
+	time.Sleep(time.Millisecond)
+
+	// Failures don't reset the age
+	metrics.Heartbeat("a", errors.New("oops"))
+	if metrics.lastHeartbeats["a"] != first {
+		t.Errorf("Failed heartbeat reset the age")
+	}
+	metrics.Heartbeat("a", nil)
+	if !metrics.lastHeartbeats["a"].After(first) {
+		t.Errorf("Successful heartbeat didn't reset the age")
+	}
+}
+
+// The series of the tunnels that aren't running don't pile up
+func TestPrometheusMetricsTerminatedTunnel(t *testing.T) {
+	var metrics = PrometheusMetrics{}
+	var down = []string{"terminated", "user shutdown", "halting", "shutdown"}
+	for i, tunnel := range []string{"a", "b", "c", "d", "e"} {
+		metrics.Heartbeat(tunnel, nil)
+		metrics.Heartbeat(tunnel, errors.New("oops"))
+		metrics.TunnelStatus(tunnel, "running")
+		if i < len(down) {
+			metrics.TunnelStatus(tunnel, down[i])
+		}
+	}
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	for _, tunnel := range []string{"a", "b", "c", "d"} {
+		if strings.Contains(b.String(), `tunnel="`+tunnel+`"`) {
+			t.Errorf("Tunnel %s still reported:\n%s", tunnel, b.String())
+		}
+	}
+	if !strings.Contains(b.String(),
+		`sauceproxy_heartbeats_total{tunnel="e",result="failure"} 1`) {
+		t.Errorf("Running tunnel not reported:\n%s", b.String())
+	}
+	if len(metrics.heartbeats) != 2 || len(metrics.lastHeartbeats) != 1 ||
+		len(metrics.statuses) != 1 {
+		t.Errorf("Series left %v %v %v", metrics.heartbeats,
+			metrics.lastHeartbeats, metrics.statuses)
+	}
+}
+
+func TestPrometheusMetricsServeHTTP(t *testing.T) {
+	var metrics = &PrometheusMetrics{}
+	metrics.TunnelStatus("a\"b", "running")
+
+	var server = httptest.NewServer(metrics)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET errored %+v\n", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Invalid content type %s", resp.Header.Get("Content-Type"))
+	}
+	var line = `sauceproxy_tunnel_status{tunnel="a\"b",status="running"} 1`
+	if !strings.Contains(string(body), line) {
+		t.Errorf("Missing line %s in:\n%s", line, body)
+	}
+}
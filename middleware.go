@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"net/http"
+)
+
+//
+// Send a request and return its response, like http.Client.Do.
+//
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+//
+// Wrap the sending of the requests, to add headers, retry or inject faults for
+// instance. A middleware calls `next` to send the request on, and can change
+// the request before, and the response after.
+//
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+//
+// Return the function sending the requests of the client: its Breaker, its
+// Limiter and its Middlewares, the first one being the outermost, around
+// ExecuteRequest.
+//
+func (c *Client) roundTrip() RoundTripFunc {
+	var do RoundTripFunc = http.DefaultClient.Do
+	if c.ExecuteRequest != nil {
+		do = c.ExecuteRequest
+	}
+
+	for i := len(c.Middlewares) - 1; i >= 0; i-- {
+		do = c.Middlewares[i](do)
+	}
+	if c.Limiter != nil {
+		do = c.Limiter.Middleware()(do)
+	}
+	// Fail fast rather than wait for the limiter
+	if c.Breaker != nil {
+		do = c.Breaker.Middleware()(do)
+	}
+
+	return do
+}
+
+//
+// Middleware setting the headers `header` on every request.
+//
+func SetHeaders(header http.Header) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			for key, values := range header {
+				req.Header[http.CanonicalHeaderKey(key)] = values
+			}
+			return next(req)
+		}
+	}
+}
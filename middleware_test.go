@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Middleware appending `name` to `calls` before and after the request
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			*calls = append(*calls, name+" before")
+			resp, err := next(req)
+			*calls = append(*calls, name+" after")
+			return resp, err
+		}
+	}
+}
+
+func TestClientMiddlewaresOrder(t *testing.T) {
+	var server = multiResponseServer([]R{stringResponse(statusRunningJSON)})
+	defer server.Close()
+
+	var calls []string
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		ExecuteRequest: func(req *http.Request) (*http.Response, error) {
+			calls = append(calls, "execute")
+			return http.DefaultClient.Do(req)
+		},
+		Middlewares: []Middleware{
+			recordingMiddleware("outer", &calls),
+			recordingMiddleware("inner", &calls),
+		},
+	}
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Fatalf("client.Status errored %+v\n", err)
+	}
+
+	var expected = "outer before, inner before, execute, inner after, outer after"
+	if strings.Join(calls, ", ") != expected {
+		t.Errorf("Invalid calls %v, expected %s", calls, expected)
+	}
+}
+
+func TestClientMiddlewareSetHeaders(t *testing.T) {
+	var header string
+	var authorization string
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header.Get("X-Team")
+			authorization = r.Header.Get("Authorization")
+			io.WriteString(w, statusRunningJSON)
+		}))
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:     server.URL,
+		Username:    "username",
+		Password:    "password",
+		Middlewares: []Middleware{SetHeaders(http.Header{"x-team": {"ci"}})},
+	}
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Fatalf("client.Status errored %+v\n", err)
+	}
+	if header != "ci" {
+		t.Errorf("Invalid X-Team header %q", header)
+	}
+	// Middlewares get authenticated requests
+	if !strings.HasPrefix(authorization, "Basic ") {
+		t.Errorf("Invalid Authorization header %q", authorization)
+	}
+}
+
+func TestClientMiddlewareFaultInjection(t *testing.T) {
+	var sent = false
+	var client = Client{
+		BaseURL:  "http://localhost:1",
+		Username: "username",
+		ExecuteRequest: func(req *http.Request) (*http.Response, error) {
+			sent = true
+			return nil, errors.New("unreachable")
+		},
+		Middlewares: []Middleware{
+			func(next RoundTripFunc) RoundTripFunc {
+				return func(req *http.Request) (*http.Response, error) {
+					return nil, errors.New("injected fault")
+				}
+			},
+		},
+	}
+
+	_, err := client.Status("fakeid")
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) || connErr.Err.Error() != "injected fault" {
+		t.Errorf("Invalid error %v", err)
+	}
+	if sent {
+		t.Errorf("ExecuteRequest called despite the fault")
+	}
+}
+
+// A middleware can send the request again, body included
+func TestClientMiddlewareRetry(t *testing.T) {
+	var bodies []string
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			if len(bodies) == 1 {
+				http.Error(w, "unavailable", 503)
+				return
+			}
+			io.WriteString(w, `{"result": true}`)
+		}))
+	defer server.Close()
+
+	var retry = func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			resp, err := next(req)
+			if err == nil && resp.StatusCode == 503 {
+				resp.Body.Close()
+				var retried = req.Clone(req.Context())
+				retried.Body, _ = req.GetBody()
+				return next(retried)
+			}
+			return resp, err
+		}
+	}
+	var client = Client{BaseURL: server.URL, Username: "username", Middlewares: []Middleware{retry}}
+
+	if err := client.Ping("fakeid", true, time.Second); err != nil {
+		t.Fatalf("client.Ping errored %+v\n", err)
+	}
+	if len(bodies) != 2 || bodies[0] == "" || bodies[0] != bodies[1] {
+		t.Errorf("Invalid bodies %q", bodies)
+	}
+}
@@ -0,0 +1,114 @@
+//
+// Package oteltracing sends the traces of the sauceproxy-rest Client to
+// OpenTelemetry:
+//
+//	client.Tracer = &oteltracing.Tracer{}
+//
+// The spans go to the global tracer provider by default, and the requests
+// to the REST API carry the W3C traceparent and tracestate headers.
+//
+package oteltracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/saucelabs/sauceproxy-rest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//
+// Name of the tracer taken from the global tracer provider.
+//
+const TracerName = "github.com/saucelabs/sauceproxy-rest"
+
+//
+// rest.Tracer creating OpenTelemetry spans. The zero value uses the global
+// tracer provider, and propagates the W3C trace context.
+//
+type Tracer struct {
+	// Tracer of the spans, the TracerName one of the global tracer
+	// provider if nil.
+	Tracer trace.Tracer
+	// Propagator adding the trace context to the requests, the W3C trace
+	// context if nil. The global one isn't used, as it propagates nothing
+	// unless the application sets it.
+	Propagator propagation.TextMapPropagator
+}
+
+func (t *Tracer) Start(
+	ctx context.Context, name string,
+) (context.Context, rest.Span) {
+	var tracer = t.Tracer
+	if tracer == nil {
+		tracer = otel.GetTracerProvider().Tracer(TracerName)
+	}
+	var propagator = t.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+
+	ctx, span := tracer.Start(ctx, name)
+	return ctx, &otelSpan{ctx: ctx, span: span, propagator: propagator}
+}
+
+type otelSpan struct {
+	ctx        context.Context
+	span       trace.Span
+	propagator propagation.TextMapPropagator
+}
+
+func (s *otelSpan) SetAttributes(attrs ...rest.Attribute) {
+	s.span.SetAttributes(keyValues(attrs)...)
+}
+
+func (s *otelSpan) AddEvent(name string, attrs ...rest.Attribute) {
+	s.span.AddEvent(name, trace.WithAttributes(keyValues(attrs)...))
+}
+
+func (s *otelSpan) Inject(header http.Header) {
+	s.propagator.Inject(s.ctx, propagation.HeaderCarrier(header))
+}
+
+func (s *otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+//
+// Convert the attributes to OpenTelemetry ones, the values of other types
+// than the OpenTelemetry ones being formatted with fmt.
+//
+func keyValues(attrs []rest.Attribute) []attribute.KeyValue {
+	var kvs = make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		var kv attribute.KeyValue
+		switch value := attr.Value.(type) {
+		case string:
+			kv = attribute.String(attr.Key, value)
+		case bool:
+			kv = attribute.Bool(attr.Key, value)
+		case int:
+			kv = attribute.Int(attr.Key, value)
+		case int64:
+			kv = attribute.Int64(attr.Key, value)
+		case float64:
+			kv = attribute.Float64(attr.Key, value)
+		case []string:
+			kv = attribute.StringSlice(attr.Key, value)
+		default:
+			kv = attribute.String(attr.Key, fmt.Sprint(value))
+		}
+		kvs = append(kvs, kv)
+	}
+
+	return kvs
+}
@@ -0,0 +1,92 @@
+package oteltracing
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saucelabs/sauceproxy-rest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracerPropagation(t *testing.T) {
+	var traceparent string
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			io.WriteString(w, `{"id": "fakeid", "status": "running"}`)
+		}))
+	defer server.Close()
+
+	var recorder = tracetest.NewSpanRecorder()
+	var provider = sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(recorder))
+	var client = rest.Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+		Tracer:   &Tracer{Tracer: provider.Tracer("test")},
+	}
+	if _, err := client.Info("fakeid"); err != nil {
+		t.Fatalf("client.Info errored %+v\n", err)
+	}
+
+	// Ended children first
+	var spans = recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %+v", spans)
+	}
+	var request, info = spans[0], spans[1]
+	if info.Name() != "Client.Info" || request.Name() != "HTTP GET" ||
+		request.Parent().SpanID() != info.SpanContext().SpanID() {
+		t.Errorf("Invalid spans %s %s", info.Name(), request.Name())
+	}
+
+	// The REST API sees the span of the request
+	var expected = "00-" + request.SpanContext().TraceID().String() + "-" +
+		request.SpanContext().SpanID().String() + "-01"
+	if traceparent != expected {
+		t.Errorf("Invalid traceparent %q, expected %q", traceparent, expected)
+	}
+
+	var attributes = map[attribute.Key]attribute.Value{}
+	for _, kv := range request.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	if attributes["http.status_code"] != attribute.IntValue(200) ||
+		attributes["attempt"] != attribute.IntValue(1) ||
+		attributes["http.method"] != attribute.StringValue("GET") {
+		t.Errorf("Invalid attributes %+v", attributes)
+	}
+	if len(request.Events()) == 0 {
+		t.Errorf("No connection events")
+	}
+}
+
+func TestTracerError(t *testing.T) {
+	var recorder = tracetest.NewSpanRecorder()
+	var provider = sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(recorder))
+	var tracer = &Tracer{Tracer: provider.Tracer("test")}
+
+	_, span := tracer.Start(t.Context(), "failing")
+	span.SetAttributes(rest.Attr("timeout", 90e9), rest.Attr("tunnels", []string{"a"}))
+	span.End(errors.New("oops"))
+
+	var spans = recorder.Ended()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error ||
+		spans[0].Status().Description != "oops" {
+		t.Fatalf("Invalid spans %+v", spans)
+	}
+	var attributes = spans[0].Attributes()
+	if len(attributes) != 2 ||
+		attributes[0].Value != attribute.Float64Value(90e9) ||
+		attributes[1].Value.AsStringSlice()[0] != "a" {
+		t.Errorf("Invalid attributes %+v", attributes)
+	}
+}
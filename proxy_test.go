@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// CONNECT proxy requiring the credentials `user` and `password`, recording
+// the targets of the tunnels
+type connectProxy struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	targets []string
+}
+
+func newConnectProxy(user, password string) *connectProxy {
+	var proxy = &connectProxy{}
+	var expected = "Basic " +
+		base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+
+	proxy.Server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "CONNECT" {
+				http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+				return
+			}
+			if r.Header.Get("Proxy-Authorization") != expected {
+				http.Error(w, "denied", http.StatusProxyAuthRequired)
+				return
+			}
+			upstream, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			proxy.mutex.Lock()
+			proxy.targets = append(proxy.targets, r.Host)
+			proxy.mutex.Unlock()
+
+			conn, buffered, _ := w.(http.Hijacker).Hijack()
+			io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			go func() {
+				io.Copy(upstream, bufio.NewReader(buffered))
+				upstream.Close()
+			}()
+			io.Copy(conn, upstream)
+			conn.Close()
+		}))
+	return proxy
+}
+
+func (p *connectProxy) Targets() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string{}, p.targets...)
+}
+
+// Query the status of a tunnel on the TLS server `server` through the
+// proxies of `config`
+func statusThroughProxy(
+	t *testing.T, server *httptest.Server, config ProxyConfig,
+) error {
+	proxyFunc, err := config.ProxyFunc()
+	if err != nil {
+		t.Fatalf("ProxyFunc errored %+v\n", err)
+	}
+	var transport = server.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc
+
+	var client = Client{
+		BaseURL:        server.URL,
+		Username:       "username",
+		ExecuteRequest: (&http.Client{Transport: transport}).Do,
+	}
+	_, err = client.Status("fakeid")
+	return err
+}
+
+func TestProxyConfigConnect(t *testing.T) {
+	var server = httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, statusRunningJSON)
+		}))
+	defer server.Close()
+	var proxy = newConnectProxy("user", "p@ss")
+	defer proxy.Close()
+	var proxyHost = strings.TrimPrefix(proxy.URL, "http://")
+	var target = strings.TrimPrefix(server.URL, "https://")
+
+	// Credentials in the URL, or given separately
+	var withUser = "http://user:" + url.QueryEscape("p@ss") + "@" + proxyHost
+	for _, config := range []ProxyConfig{
+		{URL: withUser},
+		{URL: proxyHost, Username: "user", Password: "p@ss"},
+		{URL: "http://other:secret@" + proxyHost, Username: "user", Password: "p@ss"},
+	} {
+		if err := statusThroughProxy(t, server, config); err != nil {
+			t.Errorf("%+v: client.Status errored %+v\n", config, err)
+		}
+	}
+	if targets := proxy.Targets(); len(targets) != 3 || targets[0] != target {
+		t.Errorf("Invalid tunnels %v", targets)
+	}
+
+	// Wrong credentials
+	var err = statusThroughProxy(t, server, ProxyConfig{URL: proxyHost})
+	if err == nil || !strings.Contains(err.Error(), "Proxy Authentication Required") {
+		t.Errorf("Invalid error %v", err)
+	}
+
+	// Direct connections
+	for _, noProxy := range []string{"127.0.0.1", "10.0.0.0/8, 127.0.0.0/8", "*"} {
+		var config = ProxyConfig{URL: withUser, NoProxy: []string{noProxy}}
+		if err := statusThroughProxy(t, server, config); err != nil {
+			t.Errorf("%s: client.Status errored %+v\n", noProxy, err)
+		}
+	}
+	if targets := proxy.Targets(); len(targets) != 3 {
+		t.Errorf("No proxy ignored: %v", targets)
+	}
+}
+
+func TestNoProxyMatch(t *testing.T) {
+	list, err := parseNoProxy([]string{
+		"example.com, .corp.local,internal:8080",
+		"192.168.1.1", "[::1]", "10.0.0.0/8",
+	})
+	if err != nil {
+		t.Fatalf("parseNoProxy errored %+v\n", err)
+	}
+
+	for rawurl, expected := range map[string]bool{
+		"https://example.com/":          true,
+		"https://api.example.com/":      true,
+		"https://notexample.com/":       false,
+		"http://wiki.corp.local/":       true,
+		"http://corp.local/":            true,
+		"http://internal:8080/":         true,
+		"http://internal/":              false,
+		"http://192.168.1.1:3000/":      true,
+		"http://192.168.1.2/":           false,
+		"http://[::1]:8080/":            true,
+		"http://10.20.30.40/":           true,
+		"https://saucelabs.com/rest/v1": false,
+	} {
+		var u, _ = url.Parse(rawurl)
+		if list.match(u) != expected {
+			t.Errorf("%s: match isn't %v", rawurl, expected)
+		}
+	}
+
+	if _, err := parseNoProxy([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("Invalid CIDR accepted")
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for value, expected := range map[string]string{
+		"proxy:3128":               "http://proxy:3128",
+		"https://proxy:443":        "https://proxy:443",
+		"socks5://u:p@proxy:1080":  "socks5://u:p@proxy:1080",
+		"ftp://proxy:21":           "",
+		"http://":                  "",
+		"http://proxy:3128/%zz":    "",
+		"http://user:pass@p:3128/": "http://user:pass@p:3128/",
+	} {
+		proxy, err := parseProxyURL(value)
+		if expected == "" && err == nil {
+			t.Errorf("%s: no error", value)
+		} else if expected != "" && (err != nil || proxy.String() != expected) {
+			t.Errorf("%s: got %v, %v, expected %s", value, proxy, err, expected)
+		}
+	}
+}
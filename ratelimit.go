@@ -0,0 +1,318 @@
+package rest
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Token bucket: Rate requests per second on average, and up to Burst at once
+// (1 if zero). A zero Rate doesn't limit anything.
+//
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+//
+// Rate limiter of the requests of a Client, set it in Client.Limiter. A
+// limiter can be shared by several clients, and is safe to use across
+// goroutines.
+//
+// The requests are split in three classes with their own limits: the
+// heartbeats, the other writes (POST, PUT and DELETE) and the reads. All of
+// them also share Total, and the heartbeats waiting for it go first so they
+// aren't starved by status polling. The heartbeats held back by their own
+// limit don't hold back the other requests.
+//
+// When the REST API answers with Retry-After, or says no requests are left
+// with the X-RateLimit-Remaining and X-RateLimit-Reset headers, all the
+// requests are held back until then, for MaxPause at most.
+//
+// A heartbeat is never held back longer than MaxHeartbeatDelay: it is then
+// sent regardless of the limits, so that the tunnel isn't taken for dead.
+//
+type RateLimiter struct {
+	Reads      RateLimit
+	Writes     RateLimit
+	Heartbeats RateLimit
+	Total      RateLimit
+	// Longest pause asked by the REST API that is followed, DefaultMaxPause
+	// if zero.
+	MaxPause time.Duration
+	// Longest a heartbeat is held back, DefaultMaxHeartbeatDelay if zero.
+	// Keep it below the interval between two heartbeats.
+	MaxHeartbeatDelay time.Duration
+
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	// Number of heartbeats waiting for a token of Total
+	heartbeatsWaiting int
+	// Closed when the last heartbeat waiting for Total is sent
+	heartbeatsSent chan struct{}
+	pausedUntil    time.Time
+}
+
+//
+// Longest pause asked by the REST API that RateLimiter follows by default.
+//
+const DefaultMaxPause = 5 * time.Minute
+
+//
+// Longest a heartbeat is held back by a RateLimiter by default, the interval
+// between two heartbeats of a Tunnel.
+//
+const DefaultMaxHeartbeatDelay = 30 * time.Second
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+//
+// Refill the bucket, and return how long to wait for a token.
+//
+func (b *bucket) delay(now time.Time) time.Duration {
+	if b.limit.Rate <= 0 {
+		return 0
+	}
+	var burst = math.Max(1, float64(b.limit.Burst))
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst,
+			b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+func (b *bucket) take() {
+	if b.limit.Rate > 0 {
+		b.tokens -= 1
+	}
+}
+
+//
+// Return the class of `req`: "heartbeat", "write" or "read".
+//
+func requestClass(req *http.Request) string {
+	switch {
+	case req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/connected"):
+		return "heartbeat"
+	case req.Method == "GET" || req.Method == "HEAD":
+		return "read"
+	default:
+		return "write"
+	}
+}
+
+func (l *RateLimiter) bucket(class string) *bucket {
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{
+			"read":      {limit: l.Reads},
+			"write":     {limit: l.Writes},
+			"heartbeat": {limit: l.Heartbeats},
+			"total":     {limit: l.Total},
+		}
+	}
+
+	return l.buckets[class]
+}
+
+// The caller holds the mutex
+func (l *RateLimiter) maxPause() time.Duration {
+	if l.MaxPause <= 0 {
+		return DefaultMaxPause
+	}
+	return l.MaxPause
+}
+
+func (l *RateLimiter) maxHeartbeatDelay() time.Duration {
+	if l.MaxHeartbeatDelay <= 0 {
+		return DefaultMaxHeartbeatDelay
+	}
+	return l.MaxHeartbeatDelay
+}
+
+//
+// Count a heartbeat in or out of the ones waiting for Total, and wake up the
+// requests letting them go first when there are none left. The caller holds
+// the mutex.
+//
+func (l *RateLimiter) heartbeatWaiting(waiting bool) {
+	if waiting {
+		l.heartbeatsWaiting += 1
+		return
+	}
+
+	l.heartbeatsWaiting -= 1
+	if l.heartbeatsWaiting == 0 && l.heartbeatsSent != nil {
+		close(l.heartbeatsSent)
+		l.heartbeatsSent = nil
+	}
+}
+
+//
+// Wait until a request of `class` can be sent, or `ctx` is done.
+//
+func (l *RateLimiter) wait(ctx context.Context, class string) error {
+	// Heartbeats are sent late rather than not at all
+	var deadline time.Time
+	if class == "heartbeat" {
+		deadline = time.Now().Add(l.maxHeartbeatDelay())
+	}
+
+	// Whether this heartbeat is counted in heartbeatsWaiting
+	var waiting = false
+	defer func() {
+		if waiting {
+			l.mutex.Lock()
+			l.heartbeatWaiting(false)
+			l.mutex.Unlock()
+		}
+	}()
+
+	for {
+		l.mutex.Lock()
+		var now = time.Now()
+		var own, total = l.bucket(class), l.bucket("total")
+		var ownDelay, totalDelay = own.delay(now), total.delay(now)
+		var delay = time.Duration(math.Max(
+			float64(ownDelay), float64(totalDelay)))
+		var paused = l.pausedUntil.Sub(now)
+		if paused > delay {
+			delay = paused
+		}
+
+		// Let the heartbeats waiting for Total have its next token
+		var heartbeatsSent chan struct{}
+		if delay <= 0 && class != "heartbeat" && l.heartbeatsWaiting > 0 {
+			if l.heartbeatsSent == nil {
+				l.heartbeatsSent = make(chan struct{})
+			}
+			heartbeatsSent = l.heartbeatsSent
+		} else if delay <= 0 || (!deadline.IsZero() && !now.Before(deadline)) {
+			own.take()
+			total.take()
+			l.mutex.Unlock()
+			return nil
+		}
+		if !deadline.IsZero() && delay > deadline.Sub(now) {
+			delay = deadline.Sub(now)
+		}
+		// Only Total is shared with the other requests: a heartbeat held
+		// back by its own limit or a pause wouldn't get its token sooner
+		// by making them wait.
+		if onTotal := class == "heartbeat" && ownDelay <= 0 &&
+			paused <= 0; onTotal != waiting {
+			waiting = onTotal
+			l.heartbeatWaiting(waiting)
+		}
+		l.mutex.Unlock()
+
+		if heartbeatsSent != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-heartbeatsSent:
+			}
+			continue
+		}
+		var timer = time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+//
+// Hold the requests back if `resp` asks for it.
+//
+func (l *RateLimiter) observe(resp *http.Response) {
+	var now = time.Now()
+	var until time.Time
+
+	if resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable {
+		until = retryAfter(resp.Header.Get("Retry-After"), now)
+	}
+
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		if resp.Header.Get(prefix+"Remaining") != "0" {
+			continue
+		}
+		var reset = rateLimitReset(resp.Header.Get(prefix+"Reset"), now)
+		if reset.After(until) {
+			until = reset
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if limit := now.Add(l.maxPause()); until.After(limit) {
+		until = limit
+	}
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+//
+// Parse a Retry-After header: a number of seconds or an HTTP date.
+//
+func retryAfter(value string, now time.Time) time.Time {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date
+	}
+	return time.Time{}
+}
+
+//
+// Parse a rate limit reset header: a number of seconds, or a Unix timestamp
+// for the large values.
+//
+func rateLimitReset(value string, now time.Time) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	if seconds > 1e9 {
+		return time.Unix(seconds, 0)
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
+
+//
+// Return the limiter as a middleware.
+//
+func (l *RateLimiter) Middleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if err := l.wait(req.Context(), requestClass(req)); err != nil {
+				return nil, err
+			}
+			resp, err := next(req)
+			if err == nil {
+				l.observe(resp)
+			}
+			return resp, err
+		}
+	}
+}
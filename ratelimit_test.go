@@ -0,0 +1,253 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBucket(t *testing.T) {
+	var server = multiResponseServer([]R{stringResponse(statusRunningJSON)})
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Limiter:  &RateLimiter{Reads: RateLimit{Rate: 20, Burst: 2}},
+	}
+
+	var start = time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := client.Status("fakeid"); err != nil {
+			t.Fatalf("client.Status errored %+v\n", err)
+		}
+	}
+	// The burst goes right away, then one request every 50ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("4 requests sent in %s", elapsed)
+	}
+}
+
+func TestRateLimiterClasses(t *testing.T) {
+	var limiter = RateLimiter{Reads: RateLimit{Rate: 0.1}}
+	var ctx = context.Background()
+
+	limiter.wait(ctx, "read")
+	var start = time.Now()
+	limiter.wait(ctx, "heartbeat")
+	limiter.wait(ctx, "write")
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Other classes held back by the reads for %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, "read"); err != context.DeadlineExceeded {
+		t.Errorf("Invalid error %v", err)
+	}
+}
+
+func TestRequestClass(t *testing.T) {
+	for expected, req := range map[string][2]string{
+		"heartbeat": {"POST", "http://localhost/user/tunnels/id/connected"},
+		"write":     {"POST", "http://localhost/user/tunnels"},
+		"read":      {"GET", "http://localhost/user/tunnels/id"},
+	} {
+		r, _ := http.NewRequest(req[0], req[1], nil)
+		if class := requestClass(r); class != expected {
+			t.Errorf("%s %s: got class %s, expected %s",
+				req[0], req[1], class, expected)
+		}
+	}
+	r, _ := http.NewRequest("DELETE", "http://localhost/user/tunnels/id", nil)
+	if class := requestClass(r); class != "write" {
+		t.Errorf("DELETE: got class %s", class)
+	}
+}
+
+// Heartbeats go first when the status polling uses all of Total
+func TestRateLimiterHeartbeatPriority(t *testing.T) {
+	var limiter = RateLimiter{Total: RateLimit{Rate: 20, Burst: 1}}
+	var ctx, cancel = context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for limiter.wait(ctx, "read") == nil {
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		var start = time.Now()
+		limiter.wait(context.Background(), "heartbeat")
+		// One token every 50ms, the heartbeat gets the next one
+		if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+			t.Errorf("Heartbeat waited %s", elapsed)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	var server = multiResponseServer([]R{
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "120")
+			http.Error(w, "slow down", 429)
+		},
+	})
+	defer server.Close()
+
+	var limiter = &RateLimiter{}
+	var client = Client{BaseURL: server.URL, Username: "username", Limiter: limiter}
+	client.Status("fakeid")
+
+	var paused = time.Until(limiter.pausedUntil)
+	if paused < 110*time.Second || paused > 120*time.Second {
+		t.Errorf("Invalid pause %s", paused)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, "heartbeat"); err != context.DeadlineExceeded {
+		t.Errorf("Requests not held back: %v", err)
+	}
+}
+
+func TestRateLimiterObserveHeaders(t *testing.T) {
+	var now = time.Now()
+	var reset = now.Add(time.Hour).Truncate(time.Second)
+
+	for _, c := range []struct {
+		status   int
+		header   http.Header
+		expected time.Time
+	}{
+		{429, http.Header{"Retry-After": {"30"}}, now.Add(30 * time.Second)},
+		{503, http.Header{"Retry-After": {reset.UTC().Format(http.TimeFormat)}}, reset},
+		{200, http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {"60"}}, now.Add(time.Minute)},
+		{200, http.Header{"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"60"}}, now.Add(time.Minute)},
+		{200, http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(reset.Unix(), 10)}}, reset},
+		{200, http.Header{"X-Ratelimit-Remaining": {"5"}, "X-Ratelimit-Reset": {"60"}}, time.Time{}},
+		{200, http.Header{"Retry-After": {"30"}}, time.Time{}},
+	} {
+		var limiter = RateLimiter{MaxPause: 2 * time.Hour}
+		limiter.observe(&http.Response{StatusCode: c.status, Header: c.header})
+
+		var diff = limiter.pausedUntil.Sub(c.expected)
+		if diff < -time.Second || diff > time.Second {
+			t.Errorf("%d %v: paused until %s, expected %s",
+				c.status, c.header, limiter.pausedUntil, c.expected)
+		}
+	}
+}
+
+// The REST API can't hold the requests back for ever
+func TestRateLimiterMaxPause(t *testing.T) {
+	var limiter = RateLimiter{}
+	limiter.observe(&http.Response{
+		StatusCode: 429,
+		Header:     http.Header{"Retry-After": {"86400"}},
+	})
+	if paused := time.Until(limiter.pausedUntil); paused > DefaultMaxPause {
+		t.Errorf("Paused for %s", paused)
+	}
+
+	limiter = RateLimiter{MaxPause: time.Second}
+	limiter.observe(&http.Response{
+		StatusCode: 503,
+		Header:     http.Header{"Retry-After": {"120"}},
+	})
+	if paused := time.Until(limiter.pausedUntil); paused > time.Second {
+		t.Errorf("Paused for %s", paused)
+	}
+}
+
+// Heartbeats are sent late rather than not at all, the reads waiting behind
+// them go once they are sent
+func TestRateLimiterHeartbeatDeadline(t *testing.T) {
+	var limiter = RateLimiter{
+		Heartbeats:        RateLimit{Rate: 0.001},
+		MaxHeartbeatDelay: 50 * time.Millisecond,
+	}
+	limiter.wait(context.Background(), "heartbeat")
+	limiter.pausedUntil = time.Now().Add(time.Minute)
+
+	var start = time.Now()
+	if err := limiter.wait(context.Background(), "heartbeat"); err != nil {
+		t.Fatalf("limiter.wait errored %+v\n", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond ||
+		elapsed > time.Second {
+		t.Errorf("Heartbeat waited %s", elapsed)
+	}
+
+	// The pause still holds the other requests back
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, "read"); err != context.DeadlineExceeded {
+		t.Errorf("Read not held back: %v", err)
+	}
+}
+
+// The reads waiting for a heartbeat go as soon as it is sent
+func TestRateLimiterHeartbeatWakeUp(t *testing.T) {
+	var limiter = RateLimiter{Total: RateLimit{Rate: 10}}
+	limiter.wait(context.Background(), "read")
+
+	go limiter.wait(context.Background(), "heartbeat")
+	time.Sleep(20 * time.Millisecond)
+
+	// The heartbeat gets the token 100ms after the first one, the read the
+	// one after
+	var start = time.Now()
+	if err := limiter.wait(context.Background(), "read"); err != nil {
+		t.Fatalf("limiter.wait errored %+v\n", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond ||
+		elapsed > 250*time.Millisecond {
+		t.Errorf("Read waited %s", elapsed)
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.heartbeatsWaiting != 0 {
+		t.Errorf("Read sent before the waiting heartbeat")
+	}
+}
+
+// The heartbeats held back by their own limit don't hold back the reads
+func TestRateLimiterHeartbeatOwnLimit(t *testing.T) {
+	var limiter = RateLimiter{
+		Heartbeats: RateLimit{Rate: 1},
+		Total:      RateLimit{Rate: 100, Burst: 10},
+	}
+	limiter.wait(context.Background(), "heartbeat")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go limiter.wait(ctx, "heartbeat")
+	time.Sleep(20 * time.Millisecond)
+
+	var start = time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.wait(context.Background(), "read"); err != nil {
+			t.Fatalf("limiter.wait errored %+v\n", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Reads waited %s", elapsed)
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.heartbeatsWaiting != 0 {
+		t.Errorf("Heartbeat counted as waiting for Total")
+	}
+}
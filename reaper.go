@@ -0,0 +1,185 @@
+package rest
+
+import (
+	"fmt"
+	"time"
+)
+
+//
+// Rules deciding which tunnels a Reaper shuts down. A zero value disables the
+// rule, a tunnel is shut down as soon as it breaks one of them.
+//
+type ReapPolicy struct {
+	// Shut down the tunnels that haven't seen their KGP client for this long.
+	// Tunnels that never connected count from their creation.
+	MaxIdle time.Duration
+	// Shut down the tunnels created more than this long ago.
+	MaxAge time.Duration
+	// Return the hostnames of the machines still allowed to run tunnels. The
+	// tunnels whose Metadata.Hostname isn't in the list are shut down, the
+	// ones without a hostname are left alone. An empty list is an error, it
+	// is more likely a truncated inventory than a fleet without machines.
+	Inventory func() ([]string, error)
+
+	// Let the jobs running on the tunnels finish before they go down.
+	WaitForJobs bool
+	// Only report the tunnels that break the rules.
+	DryRun bool
+}
+
+//
+// A tunnel that a Reaper shut down, or would have in dry-run mode.
+//
+type ReapAction struct {
+	Time        time.Time
+	Tunnel      TunnelInfo
+	Reason      string
+	DryRun      bool
+	JobsRunning int
+	Err         error
+}
+
+//
+// Periodically look for orphaned tunnels, and shut them down according to
+// Policy. Create it with the Client to use, and call Run or Reap.
+//
+type Reaper struct {
+	Client *Client
+	Policy ReapPolicy
+	// Time between two passes of Run, DefaultReapInterval if zero.
+	Interval time.Duration
+
+	// Called for every action, this is where to keep an audit log.
+	OnReap func(ReapAction)
+}
+
+//
+// Time between two passes of a Reaper without Interval.
+//
+const DefaultReapInterval = 5 * time.Minute
+
+func (r *Reaper) interval() time.Duration {
+	if r.Interval <= 0 {
+		return DefaultReapInterval
+	}
+	return r.Interval
+}
+
+//
+// Return why the tunnel breaks the policy, or an empty string if it doesn't.
+//
+func (p *ReapPolicy) check(
+	now time.Time,
+	t *TunnelInfo,
+	inventory map[string]bool,
+) string {
+	var created = time.Unix(t.CreationTime, 0)
+
+	if p.MaxAge != 0 && t.CreationTime != 0 && now.Sub(created) > p.MaxAge {
+		return fmt.Sprintf(
+			"created %s ago, max age is %s",
+			now.Sub(created).Round(time.Second), p.MaxAge)
+	}
+	if p.MaxIdle != 0 {
+		var last = created
+		if t.LastConnected != nil {
+			last = time.Unix(*t.LastConnected, 0)
+		}
+		if t.CreationTime != 0 && now.Sub(last) > p.MaxIdle {
+			return fmt.Sprintf(
+				"no KGP connection for %s, max idle time is %s",
+				now.Sub(last).Round(time.Second), p.MaxIdle)
+		}
+	}
+	if inventory != nil && t.Metadata.Hostname != "" &&
+		!inventory[t.Metadata.Hostname] {
+		return fmt.Sprintf("host %q isn't in the inventory", t.Metadata.Hostname)
+	}
+
+	return ""
+}
+
+//
+// Look for tunnels breaking the policy once, and shut them down.
+//
+func (r *Reaper) Reap() (actions []ReapAction, err error) {
+	var inventory map[string]bool
+	if r.Policy.Inventory != nil {
+		hosts, err := r.Policy.Inventory()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read the inventory: %s", err)
+		}
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("the inventory is empty, not reaping anything")
+		}
+		inventory = make(map[string]bool)
+		for _, host := range hosts {
+			inventory[host] = true
+		}
+	}
+
+	tunnels, err := r.Client.ListDetailed(ListOptions{})
+	if err != nil {
+		return
+	}
+
+	var now = time.Now()
+	var ids []string
+	for i := range tunnels {
+		var t = &tunnels[i]
+		// Leave the tunnels already going down alone
+		if state := t.State(); state != "new" && state != "running" {
+			continue
+		}
+		if reason := r.Policy.check(now, t, inventory); reason != "" {
+			actions = append(actions, ReapAction{
+				Time:   now,
+				Tunnel: *t,
+				Reason: reason,
+				DryRun: r.Policy.DryRun,
+			})
+			ids = append(ids, t.Id)
+		}
+	}
+
+	if !r.Policy.DryRun && len(ids) > 0 {
+		var results []ShutdownResult
+		results, _, err = r.Client.ShutdownMany(ids, ShutdownOptions{
+			WaitForJobs: r.Policy.WaitForJobs,
+		})
+		for i := range results {
+			actions[i].Time = time.Now()
+			actions[i].JobsRunning = results[i].JobsRunning
+			actions[i].Err = results[i].Err
+		}
+	}
+
+	if r.OnReap != nil {
+		for _, action := range actions {
+			r.OnReap(action)
+		}
+	}
+
+	return
+}
+
+//
+// Call Reap every Interval until `stop` is closed. Errors are passed to
+// `onError` (which can be nil) and don't stop the loop.
+//
+func (r *Reaper) Run(stop <-chan struct{}, onError func(error)) {
+	var ticker = time.NewTicker(r.interval())
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reap(); err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
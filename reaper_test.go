@@ -0,0 +1,230 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serve `tunnels` on the list endpoint, and record the shutdown requests.
+func reaperServer(tunnels string) (*httptest.Server, func() []string) {
+	var mutex sync.Mutex
+	var deleted []string
+
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "DELETE" {
+				mutex.Lock()
+				deleted = append(deleted, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+				mutex.Unlock()
+				io.WriteString(w, `{"jobs_running": 1}`)
+			} else {
+				io.WriteString(w, tunnels)
+			}
+		}))
+
+	return server, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sort.Strings(deleted)
+		return deleted
+	}
+}
+
+func reaperTunnels(now time.Time) string {
+	var ago = func(d time.Duration) int64 {
+		return now.Add(-d).Unix()
+	}
+
+	return fmt.Sprintf(`[
+		{"id": "fresh", "status": "running", "creation_time": %d,
+		 "last_connected": %d, "metadata": {"hostname": "ci-1"}},
+		{"id": "old", "status": "running", "creation_time": %d,
+		 "last_connected": %d, "metadata": {"hostname": "ci-1"}},
+		{"id": "idle", "status": "running", "creation_time": %d,
+		 "last_connected": %d, "metadata": {"hostname": "ci-1"}},
+		{"id": "never-connected", "status": "new", "creation_time": %d,
+		 "last_connected": null, "metadata": {"hostname": "ci-1"}},
+		{"id": "gone-host", "status": "running", "creation_time": %d,
+		 "last_connected": %d, "metadata": {"hostname": "ci-2"}},
+		{"id": "halting", "status": "halting", "creation_time": %d,
+		 "last_connected": null, "metadata": {"hostname": "ci-2"}}
+	]`,
+		ago(time.Minute), ago(time.Second),
+		ago(48*time.Hour), ago(time.Second),
+		ago(2*time.Hour), ago(90*time.Minute),
+		ago(2*time.Hour),
+		ago(time.Minute), ago(time.Second),
+		ago(48*time.Hour))
+}
+
+func TestReaperReap(t *testing.T) {
+	var server, deleted = reaperServer(reaperTunnels(time.Now()))
+	defer server.Close()
+
+	var audit []ReapAction
+	var reaper = Reaper{
+		Client: &Client{BaseURL: server.URL, Username: "username"},
+		Policy: ReapPolicy{
+			MaxIdle: time.Hour,
+			MaxAge:  24 * time.Hour,
+			Inventory: func() ([]string, error) {
+				return []string{"ci-1"}, nil
+			},
+		},
+		OnReap: func(a ReapAction) {
+			audit = append(audit, a)
+		},
+	}
+
+	actions, err := reaper.Reap()
+	if err != nil {
+		t.Fatalf("Reaper.Reap errored %+v\n", err)
+	}
+
+	var expected = []string{"gone-host", "idle", "never-connected", "old"}
+	if !reflect.DeepEqual(deleted(), expected) {
+		t.Errorf("Reaper shut down %v, expected %v", deleted(), expected)
+	}
+	if len(actions) != 4 || len(audit) != 4 {
+		t.Fatalf("Invalid actions %+v, audit %+v", actions, audit)
+	}
+
+	var reasons = map[string]string{}
+	for _, a := range actions {
+		if a.Err != nil || a.JobsRunning != 1 || a.DryRun {
+			t.Errorf("Invalid action: %+v", a)
+		}
+		reasons[a.Tunnel.Id] = a.Reason
+	}
+	for id, prefix := range map[string]string{
+		"old":             "created ",
+		"idle":            "no KGP connection for 1h30m",
+		"never-connected": "no KGP connection for 2h0m",
+		"gone-host":       `host "ci-2" isn't in the inventory`,
+	} {
+		if !strings.HasPrefix(reasons[id], prefix) {
+			t.Errorf("Invalid reason for %s: %s", id, reasons[id])
+		}
+	}
+}
+
+func TestReaperDryRun(t *testing.T) {
+	var server, deleted = reaperServer(reaperTunnels(time.Now()))
+	defer server.Close()
+
+	var reaper = Reaper{
+		Client: &Client{BaseURL: server.URL, Username: "username"},
+		Policy: ReapPolicy{MaxAge: 24 * time.Hour, DryRun: true},
+	}
+
+	actions, err := reaper.Reap()
+	if err != nil {
+		t.Fatalf("Reaper.Reap errored %+v\n", err)
+	}
+	if len(deleted()) != 0 {
+		t.Errorf("Reaper shut down %v in dry-run mode", deleted())
+	}
+	if len(actions) != 1 || actions[0].Tunnel.Id != "old" || !actions[0].DryRun {
+		t.Errorf("Invalid actions: %+v", actions)
+	}
+}
+
+func TestReaperInventoryError(t *testing.T) {
+	var server, deleted = reaperServer(reaperTunnels(time.Now()))
+	defer server.Close()
+
+	var reaper = Reaper{
+		Client: &Client{BaseURL: server.URL, Username: "username"},
+		Policy: ReapPolicy{
+			MaxAge: 24 * time.Hour,
+			Inventory: func() ([]string, error) {
+				return nil, fmt.Errorf("no such file")
+			},
+		},
+	}
+
+	_, err := reaper.Reap()
+	if err == nil || !strings.HasPrefix(err.Error(), "couldn't read the inventory") {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if len(deleted()) != 0 {
+		t.Errorf("Reaper shut down %v without an inventory", deleted())
+	}
+}
+
+// A truncated inventory doesn't orphan every tunnel
+func TestReaperEmptyInventory(t *testing.T) {
+	var server, deleted = reaperServer(reaperTunnels(time.Now()))
+	defer server.Close()
+
+	var reaper = Reaper{
+		Client: &Client{BaseURL: server.URL, Username: "username"},
+		Policy: ReapPolicy{
+			Inventory: func() ([]string, error) {
+				return []string{}, nil
+			},
+		},
+	}
+
+	actions, err := reaper.Reap()
+	if err == nil || !strings.HasPrefix(err.Error(), "the inventory is empty") {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if len(actions) != 0 || len(deleted()) != 0 {
+		t.Errorf("Reaper shut down %v with an empty inventory", deleted())
+	}
+}
+
+func TestReaperRun(t *testing.T) {
+	var server = multiResponseServer([]R{
+		errorResponse(503, "Not available"),
+	})
+	defer server.Close()
+
+	var reaper = Reaper{
+		Client:   &Client{BaseURL: server.URL, Username: "username"},
+		Policy:   ReapPolicy{MaxAge: time.Hour},
+		Interval: time.Millisecond,
+	}
+
+	var stop = make(chan struct{})
+	var done = make(chan struct{})
+	var errs = make(chan error, 100)
+	go func() {
+		reaper.Run(stop, func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		})
+		close(done)
+	}()
+
+	// Errors don't stop the loop
+	<-errs
+	<-errs
+	close(stop)
+	<-done
+}
+
+func TestReaperInterval(t *testing.T) {
+	for interval, expected := range map[time.Duration]time.Duration{
+		0:                DefaultReapInterval,
+		-time.Second:     DefaultReapInterval,
+		10 * time.Second: 10 * time.Second,
+	} {
+		var reaper = Reaper{Interval: interval}
+		if reaper.interval() != expected {
+			t.Errorf("%s: got %s, expected %s", interval, reaper.interval(),
+				expected)
+		}
+	}
+}
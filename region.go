@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//
+// Sauce Labs data center. Each one has its own REST API, tunnels created in a
+// region can only be used by the jobs of the same region.
+//
+type Region string
+
+const (
+	RegionUSWest1        Region = "us-west-1"
+	RegionUSEast1        Region = "us-east-1"
+	RegionUSEast4        Region = "us-east-4"
+	RegionEUCentral1     Region = "eu-central-1"
+	RegionAPACSoutheast1 Region = "apac-southeast-1"
+	// The region of SauceLabsURL
+	DefaultRegion = RegionUSWest1
+)
+
+//
+// The short names also accepted by ParseRegion.
+//
+var regionAliases = map[string]Region{
+	"us":             RegionUSWest1,
+	"us-west":        RegionUSWest1,
+	"us-east":        RegionUSEast1,
+	"eu":             RegionEUCentral1,
+	"eu-central":     RegionEUCentral1,
+	"apac":           RegionAPACSoutheast1,
+	"apac-southeast": RegionAPACSoutheast1,
+}
+
+var regions = map[Region]bool{
+	RegionUSWest1:        true,
+	RegionUSEast1:        true,
+	RegionUSEast4:        true,
+	RegionEUCentral1:     true,
+	RegionAPACSoutheast1: true,
+}
+
+//
+// Return the region named `name`, its full name or a short one like "eu".
+//
+func ParseRegion(name string) (Region, error) {
+	var lower = strings.ToLower(strings.TrimSpace(name))
+	if region, ok := regionAliases[lower]; ok {
+		return region, nil
+	}
+	if regions[Region(lower)] {
+		return Region(lower), nil
+	}
+
+	var names []string
+	for region := range regions {
+		names = append(names, string(region))
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unknown region %s, expected one of: %s",
+		name, strings.Join(names, ", "))
+}
+
+//
+// Return the URL of the site of the region: where versions.json is.
+//
+func (r Region) SiteURL() string {
+	// The US West data center also answers on the historic URL
+	if r == RegionUSWest1 {
+		return SauceLabsURL
+	}
+	return fmt.Sprintf("https://api.%s.saucelabs.com", r)
+}
+
+//
+// Return the base URL of the REST API of the region, to use as
+// Client.BaseURL.
+//
+func (r Region) RestURL() string {
+	return r.SiteURL() + "/rest/v1"
+}
+
+//
+// Return a copy of the client using the REST API of `region`, for the
+// tunnels and GetLastVersion.
+//
+func (c *Client) ForRegion(region Region) (*Client, error) {
+	region, err := ParseRegion(string(region))
+	if err != nil {
+		return nil, err
+	}
+
+	var regional = *c
+	regional.BaseURL = region.RestURL()
+	regional.VersionsURL = region.SiteURL()
+	return &regional, nil
+}
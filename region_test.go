@@ -0,0 +1,73 @@
+package rest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRegion(t *testing.T) {
+	for name, expected := range map[string]Region{
+		"us-west-1":        RegionUSWest1,
+		"us":               RegionUSWest1,
+		"EU":               RegionEUCentral1,
+		" eu-central-1 ":   RegionEUCentral1,
+		"us-east":          RegionUSEast1,
+		"us-east-4":        RegionUSEast4,
+		"apac-southeast-1": RegionAPACSoutheast1,
+	} {
+		region, err := ParseRegion(name)
+		if err != nil || region != expected {
+			t.Errorf("%q: got %s, %v, expected %s", name, region, err, expected)
+		}
+	}
+
+	_, err := ParseRegion("mars-1")
+	if err == nil || !strings.Contains(err.Error(), "eu-central-1") {
+		t.Errorf("Invalid error %v", err)
+	}
+}
+
+func TestRegionURLs(t *testing.T) {
+	if url := RegionUSWest1.RestURL(); url != "https://saucelabs.com/rest/v1" {
+		t.Errorf("Invalid US REST URL %s", url)
+	}
+	if url := RegionEUCentral1.RestURL(); url != "https://api.eu-central-1.saucelabs.com/rest/v1" {
+		t.Errorf("Invalid EU REST URL %s", url)
+	}
+	if url := RegionEUCentral1.SiteURL(); url != "https://api.eu-central-1.saucelabs.com" {
+		t.Errorf("Invalid EU site URL %s", url)
+	}
+}
+
+func TestClientForRegion(t *testing.T) {
+	var client = Client{Username: "username", Password: "password"}
+
+	regional, err := client.ForRegion("eu")
+	if err != nil {
+		t.Fatalf("client.ForRegion errored %+v\n", err)
+	}
+	if regional.BaseURL != RegionEUCentral1.RestURL() ||
+		regional.VersionsURL != RegionEUCentral1.SiteURL() ||
+		regional.Username != "username" {
+		t.Errorf("Invalid client %+v", regional)
+	}
+	if client.BaseURL != "" {
+		t.Errorf("The original client was changed")
+	}
+
+	if _, err := client.ForRegion("mars-1"); err == nil {
+		t.Errorf("client.ForRegion didn't error")
+	}
+}
+
+// GetLastVersion queries the site of the region
+func TestGetLastVersionRegion(t *testing.T) {
+	var server = multiResponseServer([]R{stringResponse(versionJson)})
+	defer server.Close()
+
+	var client = Client{VersionsURL: server.URL + "/ignored/path"}
+	build, _, err := client.GetLastVersion()
+	if err != nil || build != 42 {
+		t.Errorf("client.GetLastVersion returned %d, %v", build, err)
+	}
+}
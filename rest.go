@@ -2,13 +2,19 @@ package rest
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"runtime"
+	"sync"
 	"time"
+
+	"github.com/saucelabs/sauceproxy-rest/internal/hooks"
 )
 
 const SauceLabsURL = "https://saucelabs.com"
@@ -42,12 +48,42 @@ type Client struct {
 	BaseURL  string
 	Username string
 	Password string
+	// Where GetLastVersion looks for versions.json, SauceLabsURL if empty.
+	VersionsURL string
 
 	// Methods to override default functionality
 	DecodeJSON func(reader io.ReadCloser, v interface{}) error
 	EncodeJSON func(writer io.Writer, v interface{}) error
 	// Execute the request, http.DefaultClient.Do by default
 	ExecuteRequest func(*http.Request) (*http.Response, error)
+	// Wrap ExecuteRequest, the first middleware being the outermost. They
+	// get the requests once authenticated, and each attempt is logged,
+	// measured and traced as a whole.
+	Middlewares []Middleware
+	// Rate limit the requests if set, outside of the middlewares.
+	Limiter *RateLimiter
+	// Fail fast while the REST API is down if set, outside of the limiter.
+	Breaker *CircuitBreaker
+
+	// Add the credentials to the requests, basic authentication with
+	// Username and Password by default.
+	Auth Authenticator
+
+	// Log the requests if set, for debugging. The successful requests are
+	// then only logged there, not in Logger.
+	Debug *DebugLogger
+	// Structured logs of the requests, and of the tunnels created by the
+	// client. Nothing is logged if nil.
+	Logger *slog.Logger
+	// Measures of the requests and of the tunnels, none are taken if nil.
+	Metrics Metrics
+	// Spans of the method calls and of the requests, none are created if
+	// nil.
+	Tracer Tracer
+
+	// Intervals of the loops of the tunnels, the defaults if zero. Only the
+	// tests change them, through hooks.SetLoopIntervals.
+	intervals loopIntervals
 }
 
 //
@@ -60,15 +96,39 @@ type Client struct {
 func (c *Client) GetLastVersion() (
 	build int, downloadUrl string, err error,
 ) {
-	return c.GetLastVersionFromURL(SauceLabsURL)
+	ctx, span := c.startSpan(context.Background(), "Client.GetLastVersion")
+	defer func() { span.End(err) }()
+
+	var versionsUrl = c.VersionsURL
+	if versionsUrl == "" {
+		versionsUrl = SauceLabsURL
+	}
+	return c.getLastVersion(ctx, versionsUrl)
 }
 
 func (c *Client) GetLastVersionFromURL(versionUrl string) (
 	build int, downloadUrl string, err error,
+) {
+	ctx, span := c.startSpan(context.Background(),
+		"Client.GetLastVersionFromURL", Attr("url", versionUrl))
+	defer func() { span.End(err) }()
+
+	return c.getLastVersion(ctx, versionUrl)
+}
+
+func (c *Client) getLastVersion(ctx context.Context, versionUrl string) (
+	build int, downloadUrl string, err error,
 ) {
 	u, err := url.Parse(versionUrl)
-	u.Path = ""
-	var fullUrl = fmt.Sprintf("%s/versions.json", u)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid URL %s: %s", versionUrl, err)
+	}
+	// versions.json is always at the root of the site
+	u.Path, u.RawPath, u.RawQuery = "", "", ""
+	fullUrl, err := joinURL(u.String(), nil, "versions.json")
+	if err != nil {
+		return
+	}
 
 	// Structures we use to decode the json document
 	type jsonBuild struct {
@@ -86,7 +146,7 @@ func (c *Client) GetLastVersionFromURL(versionUrl string) (
 		} `json:"Sauce Connect"`
 	}{}
 
-	err = c.executeRequest("GET", fullUrl, nil, &jsonStruct)
+	err = c.executeRequest(ctx, "GET", fullUrl, nil, &jsonStruct)
 	if err != nil {
 		return
 	}
@@ -119,16 +179,23 @@ func (c *Client) GetLastVersionFromURL(versionUrl string) (
 	return
 }
 
-func (c *Client) ReportCrash(tunnel, info, logs string) error {
+func (c *Client) ReportCrash(tunnel, info, logs string) (err error) {
+	ctx, span := c.startSpan(context.Background(),
+		"Client.ReportCrash", Attr("tunnel", tunnel))
+	defer func() { span.End(err) }()
+
 	var doc = struct {
 		Tunnel string `json:"Tunnel"`
 		Info   string `json:"Info"`
 		Logs   string `json:"Logs"`
 	}{Tunnel: tunnel, Info: info, Logs: logs}
 
-	var url = fmt.Sprintf("%s/%s/errors", c.BaseURL, c.Username)
+	url, err := c.restURL(nil, c.Username, "errors")
+	if err != nil {
+		return
+	}
 
-	return c.executeRequest("POST", url, doc, nil)
+	return c.executeRequest(ctx, "POST", url, doc, nil)
 }
 
 func (c *Client) decode(reader io.ReadCloser, v interface{}) error {
@@ -153,41 +220,77 @@ func (c *Client) encode(writer io.Writer, v interface{}) error {
 	}
 }
 
+//
+// Error returned when the REST API couldn't be reached at all.
+//
+type ConnectionError struct {
+	URL string
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("couldn't connect to %s: %s", e.URL, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+//
+// Error returned when the REST API answered with a status other than 200 OK.
+// Body usually holds a JSON document describing the error.
+//
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf(
+		"error querying from %s, error was: %s. HTTP status: %s",
+		e.URL, e.Body, e.Status)
+}
+
 //
 // Execute HTTP request and return an io.ReadCloser to be decoded
 //
 func (c *Client) executeRequest(
+	ctx context.Context,
 	method, url string,
 	request, response interface{},
 ) error {
-	var reader io.Reader
+	var body []byte
 	// Encode request JSON if needed
 	if request != nil {
 		var buf bytes.Buffer
 		if err := c.encode(&buf, request); err != nil {
 			return err
 		}
-		reader = &buf
+		body = buf.Bytes()
 	}
 
-	req, err := http.NewRequest(method, url, reader)
+	resp, err := c.send(ctx, method, url, body, 1)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(c.Username, c.Password)
 
-	resp, err := func() (*http.Response, error) {
-		if c.ExecuteRequest == nil {
-			return http.DefaultClient.Do(req)
-		} else {
-			return c.ExecuteRequest(req)
+	// The credentials may have been rotated since we got them, let the
+	// authenticator refresh them and try again once.
+	if resp.StatusCode == http.StatusUnauthorized {
+		retry, err := c.authenticator().Refresh(resp.Request)
+		if err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("couldn't refresh credentials: %s", err)
+		}
+		if retry {
+			resp.Body.Close()
+			c.metrics().Retry(c.endpoint(method, resp.Request.URL))
+			if resp, err = c.send(ctx, method, url, body, 2); err != nil {
+				return err
+			}
 		}
-	}()
-
-	if err != nil {
-		return fmt.Errorf("couldn't connect to %s: %s", req.URL, err)
 	}
 
 	defer resp.Body.Close()
@@ -196,11 +299,12 @@ func (c *Client) executeRequest(
 		// there could be an error here in json format
 		buf := new(bytes.Buffer)
 		buf.ReadFrom(resp.Body)
-		return fmt.Errorf(
-			"error querying from %s, error was: %s. HTTP status: %s",
-			req.URL,
-			buf.String(),
-			resp.Status)
+		return &HTTPError{
+			URL:        resp.Request.URL.String(),
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       buf.String(),
+		}
 	}
 
 	// Decode response if needed
@@ -212,25 +316,139 @@ func (c *Client) executeRequest(
 	return nil
 }
 
-type tunnelState struct {
+//
+// Send an authenticated HTTP request with `body` and return the response.
+// `attempt` counts the times the request was sent, for the logs and the
+// traces.
+//
+func (c *Client) send(
+	ctx context.Context, method, url string, body []byte, attempt int,
+) (resp *http.Response, err error) {
+	ctx, span := c.startSpan(ctx, "HTTP "+method,
+		Attr("http.method", method), Attr("http.url", url),
+		Attr("attempt", attempt))
+	defer func() { span.End(err) }()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(
+		withConnectionEvents(ctx, span), method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	span.Inject(req.Header)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if err := c.authenticator().Authenticate(req); err != nil {
+		return nil, fmt.Errorf("couldn't authenticate request: %s", err)
+	}
+
+	var start = time.Now()
+	resp, err = c.roundTrip()(req)
+	var duration = time.Since(start)
+	if c.Debug != nil {
+		c.Debug.log(req, body, resp, err, duration)
+	}
+
+	var endpoint = c.endpoint(method, req.URL)
+	// The errors are returned to the caller, which decides whether they are
+	// worth more than a debug record
+	if err != nil {
+		// The requests rejected by the breaker were never sent
+		if !errors.Is(err, ErrCircuitOpen) {
+			c.metrics().Request(endpoint, 0, duration)
+		}
+		c.log().DebugContext(ctx, "REST request failed",
+			"method", method, "path", req.URL.Path,
+			"duration", duration, "attempt", attempt, "error", err)
+		return nil, &ConnectionError{URL: req.URL.String(), Err: err}
+	}
+
+	c.metrics().Request(endpoint, resp.StatusCode, duration)
+	span.SetAttributes(Attr("http.status_code", resp.StatusCode))
+	if resp.StatusCode != http.StatusOK || c.Debug == nil {
+		c.log().DebugContext(ctx, "REST request",
+			"method", method, "path", req.URL.Path, "status", resp.StatusCode,
+			"duration", duration, "attempt", attempt)
+	}
+	// Custom ExecuteRequest functions don't always fill it in
+	if resp.Request == nil {
+		resp.Request = req
+	}
+
+	return resp, nil
+}
+
+//
+// State of a tunnel as returned by the REST API. The times are Unix
+// timestamps, and are nil until the event happens.
+//
+type TunnelInfo struct {
 	Id               string   `json:"id"`
 	TunnelIdentifier string   `json:"tunnel_identifier"`
+	Status           string   `json:"status"`
+	UserShutdown     *bool    `json:"user_shutdown"`
+	Host             string   `json:"host"`
+	Ip               string   `json:"ip_address"`
+	Owner            string   `json:"owner"`
 	DomainNames      []string `json:"domain_names"`
+	DirectDomains    []string `json:"direct_domains"`
+	SharedTunnel     bool     `json:"shared_tunnel"`
+	CreationTime     int64    `json:"creation_time"`
+	LaunchTime       *int64   `json:"launch_time"`
+	LastConnected    *int64   `json:"last_connected"`
+	ShutdownTime     *int64   `json:"shutdown_time"`
+	JobsRunning      *int     `json:"jobs_running"`
+	Metadata         Metadata `json:"metadata"`
 }
 
 //
-// Return the list of tunnel states
+// Return the status of the tunnel, see Client.Status for the values.
 //
-func (c *Client) listTunnels() (states []tunnelState, err error) {
-	var url = fmt.Sprintf("%s/%s/tunnels?full=1", c.BaseURL, c.Username)
+func (info *TunnelInfo) State() string {
+	if info.UserShutdown != nil && *info.UserShutdown {
+		return "user shutdown"
+	}
+
+	return info.Status
+}
+
+//
+// Return the list of tunnel states of `owner`, including the tunnels shared
+// with it if `shared` is set. The states without an owner get `owner`.
+//
+func (c *Client) listTunnels(ctx context.Context, owner string, shared bool) (
+	states []TunnelInfo, err error,
+) {
+	var query = queryParams{{"full", "1"}}
+	if shared {
+		query = append(query, [2]string{"all", "1"})
+	}
+	url, err := c.restURL(query, owner, "tunnels")
+	if err != nil {
+		return
+	}
 
-	err = c.executeRequest("GET", url, nil, &states)
+	err = c.executeRequest(ctx, "GET", url, nil, &states)
+
+	// The REST API only tells the owner of the tunnels shared with `owner`
+	for i := range states {
+		if states[i].Owner == "" {
+			states[i].Owner = owner
+		}
+	}
 
 	return
 }
 
 func (c *Client) List() (ids []string, err error) {
-	states, err := c.listTunnels()
+	ctx, span := c.startSpan(context.Background(), "Client.List")
+	defer func() { span.End(err) }()
+
+	states, err := c.listTunnels(ctx, c.Username, false)
 	if err != nil {
 		return
 	}
@@ -242,6 +460,48 @@ func (c *Client) List() (ids []string, err error) {
 	return
 }
 
+//
+// Which tunnels Client.ListDetailed, Client.Find and Client.FindDetailed look
+// at. The
+// zero value is the tunnels of Client.Username.
+//
+type ListOptions struct {
+	// List the tunnels of this user instead of Client.Username. The parent
+	// accounts can list the tunnels of their sub-accounts.
+	Owner string
+	// Also list the tunnels shared with the owner (see Request.SharedTunnel).
+	IncludeShared bool
+}
+
+//
+// Return the full state of every tunnel selected by `opts`.
+//
+func (c *Client) ListDetailed(opts ListOptions) (tunnels []TunnelInfo, err error) {
+	ctx, span := c.startSpan(context.Background(), "Client.ListDetailed",
+		listAttributes(opts)...)
+	defer func() { span.End(err) }()
+
+	return c.listDetailed(ctx, opts)
+}
+
+func listAttributes(opts ListOptions) []Attribute {
+	return []Attribute{
+		Attr("owner", opts.Owner),
+		Attr("include_shared", opts.IncludeShared),
+	}
+}
+
+func (c *Client) listDetailed(ctx context.Context, opts ListOptions) (
+	tunnels []TunnelInfo, err error,
+) {
+	var owner = opts.Owner
+	if owner == "" {
+		owner = c.Username
+	}
+
+	return c.listTunnels(ctx, owner, opts.IncludeShared)
+}
+
 func checkOverlappingDomains(localDomains []string, remoteDomains []string) bool {
 	for _, localDomain := range localDomains {
 		for _, remoteDomain := range remoteDomains {
@@ -255,12 +515,56 @@ func checkOverlappingDomains(localDomains []string, remoteDomains []string) bool
 
 //
 // Find tunnels: named tunnel with `name`, or tunnel matching one or more of
-// `domains` if name is empty.
+// `domains` if name is empty. Look at the tunnels of Client.Username, use
+// FindDetailed for the ones of another owner or the shared ones.
 //
 func (c *Client) Find(name string, domains []string) (
 	matches []string, err error,
 ) {
-	list, err := c.listTunnels()
+	ctx, span := c.startSpan(context.Background(), "Client.Find",
+		Attr("name", name), Attr("domains", domains))
+	defer func() { span.End(err) }()
+
+	list, err := c.findDetailed(ctx, name, domains, ListOptions{})
+	if err != nil {
+		return
+	}
+
+	for _, state := range list {
+		matches = append(matches, state.Id)
+	}
+
+	return
+}
+
+//
+// Same as Find, but look at the tunnels selected by `opts`, and return the
+// full state of the matching ones.
+//
+func (c *Client) FindDetailed(
+	name string,
+	domains []string,
+	opts ListOptions,
+) (
+	matches []TunnelInfo, err error,
+) {
+	ctx, span := c.startSpan(context.Background(), "Client.FindDetailed",
+		append(listAttributes(opts),
+			Attr("name", name), Attr("domains", domains))...)
+	defer func() { span.End(err) }()
+
+	return c.findDetailed(ctx, name, domains, opts)
+}
+
+func (c *Client) findDetailed(
+	ctx context.Context,
+	name string,
+	domains []string,
+	opts ListOptions,
+) (
+	matches []TunnelInfo, err error,
+) {
+	list, err := c.listDetailed(ctx, opts)
 	if err != nil {
 		return
 	}
@@ -269,11 +573,11 @@ func (c *Client) Find(name string, domains []string) (
 		// If we're an unamed tunnel, check the overlapping domain names
 		if name == "" && state.TunnelIdentifier == "" {
 			if checkOverlappingDomains(domains, state.DomainNames) {
-				matches = append(matches, state.Id)
+				matches = append(matches, state)
 			}
 		} else if state.TunnelIdentifier == name {
 			// If we're a named tunnel, only check the tunnels' names
-			matches = append(matches, state.Id)
+			matches = append(matches, state)
 		}
 	}
 
@@ -283,22 +587,120 @@ func (c *Client) Find(name string, domains []string) (
 //
 // Shutdown tunnel `id`
 //
-func (c *Client) Shutdown(id string) (int, error) {
-	return c.shutdown("%s/%s/tunnels/%s", id)
+func (c *Client) Shutdown(id string) (jobsRunning int, err error) {
+	ctx, span := c.startSpan(context.Background(),
+		"Client.Shutdown", Attr("tunnel", id))
+	defer func() { span.End(err) }()
+
+	return c.shutdown(ctx, c.Username, id, nil)
 }
 
-func (c *Client) shutdown(urlFmt, id string) (int, error) {
-	var url = fmt.Sprintf(urlFmt, c.BaseURL, c.Username, id)
+func (c *Client) shutdown(
+	ctx context.Context, owner, id string, query queryParams,
+) (int, error) {
+	url, err := c.restURL(query, owner, "tunnels", id)
+	if err != nil {
+		return 0, err
+	}
 
 	var response struct {
 		JobsRunning int `json:"jobs_running"`
 	}
-	err := c.executeRequest("DELETE", url, nil, &response)
+	err = c.executeRequest(ctx, "DELETE", url, nil, &response)
 	jobsRunning := response.JobsRunning
+	if err == nil {
+		c.log().Info("Tunnel shutting down",
+			"tunnel", id, "owner", owner, "jobs_running", jobsRunning)
+	}
 
 	return jobsRunning, err
 }
 
+//
+// Options for Client.ShutdownMany
+//
+type ShutdownOptions struct {
+	// Number of tunnels shut down at the same time, 4 if zero.
+	Concurrency int
+	// Let the jobs running on the tunnels finish before they go down.
+	WaitForJobs bool
+	// User owning the tunnels, Client.Username if empty.
+	Owner string
+}
+
+//
+// Outcome of the shutdown of one tunnel by Client.ShutdownMany
+//
+type ShutdownResult struct {
+	Id          string
+	JobsRunning int
+	// The tunnel didn't exist anymore, this isn't considered an error.
+	AlreadyTerminated bool
+	Err               error
+}
+
+//
+// Shutdown the tunnels `ids` concurrently, and return the result for each of
+// them in the same order, along with the total number of jobs still running.
+// The error joins the errors of all the tunnels that failed to shut down.
+//
+func (c *Client) ShutdownMany(ids []string, opts ShutdownOptions) (
+	results []ShutdownResult, jobsRunning int, err error,
+) {
+	ctx, span := c.startSpan(context.Background(), "Client.ShutdownMany",
+		Attr("tunnels", ids), Attr("owner", opts.Owner),
+		Attr("wait_for_jobs", opts.WaitForJobs))
+	defer func() { span.End(err) }()
+
+	var query = waitForJobs(opts.WaitForJobs)
+	var workers = opts.Concurrency
+	if workers <= 0 {
+		workers = 4
+	}
+	var owner = opts.Owner
+	if owner == "" {
+		owner = c.Username
+	}
+
+	results = make([]ShutdownResult, len(ids))
+	var indexes = make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers && w < len(ids); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				var r = &results[i]
+				r.Id = ids[i]
+				r.JobsRunning, r.Err = c.shutdown(ctx, owner, ids[i], query)
+
+				var httpErr *HTTPError
+				if errors.As(r.Err, &httpErr) &&
+					httpErr.StatusCode == http.StatusNotFound {
+					r.AlreadyTerminated = true
+					r.Err = nil
+				}
+			}
+		}()
+	}
+	for i := range ids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		jobsRunning += r.JobsRunning
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("tunnel %s: %w", r.Id, r.Err))
+		}
+	}
+
+	return results, jobsRunning, errors.Join(errs...)
+}
+
 type Metadata struct {
 	Release     string `json:"release"`
 	GitVersion  string `json:"git_version"`
@@ -351,25 +753,129 @@ type Request struct {
 // Create a new tunnel and wait for it to come up
 //
 // This will start a goroutine to keep track of the tunnel's status using the
-// ClientStatus & ServerStatus channels
+// ClientStatus & ServerStatus channels, until Tunnel.Close is called, which
+// also cancels the requests these goroutines are sending.
+//
+// If the tunnel was created but didn't come up, it is returned along with the
+// error: shut it down with Tunnel.Shutdown to not leave it behind.
+func (c *Client) CreateTunnel(request *Request) (tunnel *Tunnel, err error) {
+	ctx, span := c.startSpan(context.Background(), "Client.CreateTunnel",
+		createAttributes(request, time.Minute)...)
+	defer func() { span.End(err) }()
+
+	return c.createAndMonitor(ctx, request, time.Minute)
+}
+
+//
+// Same as CreateTunnel, returning a copy of the tunnel.
+//
+// Deprecated: use CreateTunnel.
+//
 func (c *Client) Create(request *Request) (tunnel Tunnel, err error) {
-	tunnel, err = c.CreateWithTimeout(request, time.Minute)
+	t, err := c.CreateTunnel(request)
+	if t != nil {
+		tunnel = *t
+	}
+	return
+}
+
+//
+// Same as CreateTunnel, but wait up to `timeout` for the tunnel to come up.
+//
+func (c *Client) CreateAndMonitor(
+	request *Request,
+	timeout time.Duration,
+) (
+	tunnel *Tunnel, err error,
+) {
+	return c.CreateAndMonitorContext(context.Background(), request, timeout)
+}
+
+//
+// Same as CreateAndMonitor, but give up once `ctx` is canceled. The tunnel is
+// returned along with the error if it was created by then, shut it down.
+//
+func (c *Client) CreateAndMonitorContext(
+	ctx context.Context,
+	request *Request,
+	timeout time.Duration,
+) (
+	tunnel *Tunnel, err error,
+) {
+	ctx, span := c.startSpan(ctx, "Client.CreateAndMonitor",
+		createAttributes(request, timeout)...)
+	defer func() { span.End(err) }()
+
+	return c.createAndMonitor(ctx, request, timeout)
+}
+
+func (c *Client) createAndMonitor(
+	ctx context.Context,
+	request *Request,
+	timeout time.Duration,
+) (
+	tunnel *Tunnel, err error,
+) {
+	tunnel, err = c.createWithTimeout(ctx, request, timeout)
 
 	if err == nil {
-		go tunnel.serverStatusLoop(5 * time.Second)
-		go tunnel.heartbeatLoop(30 * time.Second)
+		var intervals = c.loopIntervals()
+		go tunnel.serverStatusLoop(intervals.status)
+		go tunnel.heartbeatLoop(intervals.heartbeat)
 	}
 	return
 }
 
 //
-// Create a new tunnel and wait for it to come up within `wait`.
+// Create a new tunnel and wait for it to come up within `timeout`, without
+// starting the goroutines of CreateTunnel. If the tunnel was created but
+// didn't come up, it is returned along with the error, see CreateTunnel.
+//
+func (c *Client) CreateTunnelWithTimeout(
+	request *Request,
+	timeout time.Duration,
+) (
+	tunnel *Tunnel, err error,
+) {
+	ctx, span := c.startSpan(context.Background(),
+		"Client.CreateTunnelWithTimeout", createAttributes(request, timeout)...)
+	defer func() { span.End(err) }()
+
+	return c.createWithTimeout(ctx, request, timeout)
+}
+
+//
+// Same as CreateTunnelWithTimeout, returning a copy of the tunnel.
+//
+// Deprecated: use CreateTunnelWithTimeout.
 //
 func (c *Client) CreateWithTimeout(
 	request *Request,
 	timeout time.Duration,
 ) (
 	tunnel Tunnel, err error,
+) {
+	t, err := c.CreateTunnelWithTimeout(request, timeout)
+	if t != nil {
+		tunnel = *t
+	}
+	return
+}
+
+func createAttributes(request *Request, timeout time.Duration) []Attribute {
+	return []Attribute{
+		Attr("tunnel_identifier", request.TunnelIdentifier),
+		Attr("domains", request.DomainNames),
+		Attr("timeout", timeout),
+	}
+}
+
+func (c *Client) createWithTimeout(
+	ctx context.Context,
+	request *Request,
+	timeout time.Duration,
+) (
+	tunnel *Tunnel, err error,
 ) {
 	var r = request
 
@@ -393,21 +899,33 @@ func (c *Client) CreateWithTimeout(
 		Ip   string `json:"ip_address"`
 		Host string `json:"host"`
 	}
-	var url = fmt.Sprintf("%s/%s/tunnels", c.BaseURL, c.Username)
-
-	err = c.executeRequest("POST", url, doc, &response)
+	url, err := c.restURL(nil, c.Username, "tunnels")
 	if err != nil {
 		return
 	}
 
-	tunnel.Client = c
-	tunnel.Id = response.Id
-	tunnel.Host, tunnel.Ip, err = tunnel.wait(timeout)
-	// Only create channels if the tunnel succesfully come up
-	if err == nil {
-		tunnel.ServerStatus = make(chan string)
-		tunnel.ClientStatus = make(chan ClientStatus)
+	err = c.executeRequest(ctx, "POST", url, doc, &response)
+	if err != nil {
+		return
+	}
+	tunnel = c.Tunnel(response.Id)
+	tunnel.KGPPort = r.KGPPort
+	// Created now to get the changes while the tunnel comes up
+	tunnel.StateChanges = make(chan StateChange, stateChangesSize)
+	tunnel.log().Info("Tunnel created",
+		"identifier", r.TunnelIdentifier, "domains", r.DomainNames)
+	endpoint, err := tunnel.wait(ctx, timeout)
+	if err != nil {
+		// The tunnel exists on the server, the caller has to shut it down
+		return tunnel, err
 	}
+	tunnel.Host, tunnel.Ip = endpoint.Host, endpoint.Ip
+	tunnel.shared.endpoint = endpoint
+	tunnel.shared.state = "running"
+	// Only create channels if the tunnel succesfully come up
+	tunnel.ServerStatus = make(chan string)
+	tunnel.ClientStatus = make(chan ClientStatus)
+	tunnel.KGPChanges = make(chan KGPEndpoint, 1)
 	return
 }
 
@@ -416,65 +934,153 @@ type ClientStatus struct {
 	LastStatusChange int64
 }
 
+// Time between two heartbeats of the tunnels created by Client.Create
+const heartbeatInterval = 30 * time.Second
+
+// Time between two status requests of the tunnels created by Client.Create
+const statusInterval = 5 * time.Second
+
 //
-// Tunnel control interface. Create it by calling Client.Create(), all methods
-// are safe to call across goroutines. Tunnel.Status() is updated every XXX
-// seconds by a goroutine that queries the state of the tunnel.
-//
-// We may want to switch the method Status with a direct access to the active
-// channel instead depending of how the main loop is done.
+// Intervals of the goroutines of the tunnels created by a Client.
 //
-type Tunnel struct {
-	Client *Client
-	Id     string
-	Host   string
-	Ip     string
-	// A channel used to communicate the state of the tunnel back to the main
-	// goroutine.
-	ServerStatus chan string
-	ClientStatus chan ClientStatus
+type loopIntervals struct {
+	status    time.Duration
+	heartbeat time.Duration
 }
 
+func (c *Client) loopIntervals() loopIntervals {
+	var intervals = c.intervals
+	if intervals.status == 0 {
+		intervals.status = statusInterval
+	}
+	if intervals.heartbeat == 0 {
+		intervals.heartbeat = heartbeatInterval
+	}
+	return intervals
+}
+
+func init() {
+	hooks.SetLoopIntervals = func(
+		client interface{}, status, heartbeat time.Duration,
+	) {
+		client.(*Client).intervals = loopIntervals{status, heartbeat}
+	}
+}
+
+//
+// Goroutine that sends the heartbeats of the tunnel until it is closed.
+//
 func (t *Tunnel) heartbeatLoop(interval time.Duration) {
 	var heartbeatTicker = time.NewTicker(interval)
+	defer heartbeatTicker.Stop()
+	var done = t.Done()
 	// Initialize the client status before we start the status loop
 	var connected = false
 	var lastChange = time.Now()
+	// No heartbeats until then, while the circuit is open
+	var pausedUntil time.Time
 
 	for {
 		select {
+		case <-done:
+			return
 		case clientStatus := <-t.ClientStatus:
 			connected = clientStatus.Connected
 			lastChange = time.Unix(clientStatus.LastStatusChange, 0)
-			var err = t.Client.Ping(t.Id, connected, time.Since(lastChange))
-			if err != nil {
-				// FIXME old sauceconnect ignores error
+			if time.Now().Before(pausedUntil) {
+				continue
 			}
+			pausedUntil = time.Now().Add(
+				retryDelay(t.heartbeat(connected, lastChange), 0))
 		case <-heartbeatTicker.C:
-			var err = t.Client.Ping(t.Id, connected, time.Since(lastChange))
-			if err != nil {
-				// FIXME old sauceconnect ignores error
+			if time.Now().Before(pausedUntil) {
+				continue
 			}
+			pausedUntil = time.Now().Add(
+				retryDelay(t.heartbeat(connected, lastChange), 0))
 		}
 	}
 }
 
 //
-// Goroutine that checks if the tunnel is still up and running
+// Send a heartbeat, the old sauceconnect ignores errors, we only log them and
+// return them.
+//
+func (t *Tunnel) heartbeat(connected bool, lastChange time.Time) error {
+	var duration = time.Since(lastChange)
+	var err = t.Client.ping(t.context(), t.ID(), connected, duration)
+	if t.context().Err() != nil {
+		// Closed while sending it
+		return err
+	} else if errors.Is(err, ErrCircuitOpen) {
+		t.log().Debug("Heartbeat skipped", "error", err)
+	} else if err != nil {
+		t.log().Warn("Heartbeat failed",
+			"kgp_connected", connected, "error", err)
+	} else {
+		t.log().Debug("Heartbeat sent",
+			"kgp_connected", connected, "since_change", duration)
+		var s = t.lock()
+		s.lastHeartbeat = time.Now()
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+//
+// Goroutine that checks if the tunnel is still up and running, until it is
+// down or closed.
 //
 func (t *Tunnel) serverStatusLoop(interval time.Duration) {
-	for range time.NewTicker(interval).C {
-		var status, err = t.Status()
-		if err != nil {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+	var done = t.Done()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		var info, err = t.Client.info(t.context(), t.ID())
+		if t.context().Err() != nil {
+			return
+		} else if errors.Is(err, ErrCircuitOpen) {
+			// Back off until the circuit lets a request through
+			var delay = retryDelay(err, interval)
+			t.log().Debug("Tunnel status polling paused",
+				"delay", delay, "error", err)
+			var timer = time.NewTimer(delay)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			ticker.Reset(interval)
+			continue
+		} else if err != nil {
 			// FIXME old sauceconnect ignores error
-		} else if status != "running" {
+			t.log().Warn("Unable to query tunnel status", "error", err)
+			continue
+		}
+
+		var status = info.State()
+		var previous = t.setState(status)
+		if status != "running" {
+			t.changeState(previous, status)
 			//
 			// The tunnel is down, send its status back to the main loop.
 			//
-			t.ServerStatus <- status
-			close(t.ServerStatus)
+			select {
+			case t.ServerStatus <- status:
+				close(t.ServerStatus)
+			case <-done:
+			}
 			return // We're done exit the loop
 		}
+		t.updateKGPEndpoint(KGPEndpoint{Host: info.Host, Ip: info.Ip})
 	}
 }
 
@@ -483,58 +1089,145 @@ func (t *Tunnel) serverStatusLoop(interval time.Duration) {
 // seconds + 60 * time the HTTP roundtrip.
 //
 // Wait for the tunnel to run
-func (t *Tunnel) wait(timeout time.Duration) (
-	host string,
-	ip string,
+func (t *Tunnel) wait(ctx context.Context, timeout time.Duration) (
+	endpoint KGPEndpoint,
 	err error,
 ) {
+	ctx, span := t.Client.startSpan(ctx, "Tunnel.wait",
+		Attr("tunnel", t.ID()), Attr("timeout", timeout))
+	defer func() { span.End(err) }()
+
 	var end = time.Now().Add(timeout)
+	// Tunnels start in the "new" state
+	var last = "new"
 
 	for {
-		status, err := t.Client.status(t.Id)
+		status, err := t.Client.status(ctx, t.ID())
 		if err != nil {
-			return "", "", err
+			// Keep polling through the outages until the timeout
+			if !transient(err) || ctx.Err() != nil || time.Now().After(end) {
+				return endpoint, err
+			}
+			t.log().Warn("Unable to query tunnel status", "error", err)
+			var delay = retryDelay(err, time.Second)
+			if remaining := time.Until(end); delay > remaining {
+				delay = remaining
+			}
+			if err := sleep(ctx, delay); err != nil {
+				return endpoint, err
+			}
+			continue
 		}
+		span.AddEvent("status", Attr("status", status.Status))
 
+		if status.Status != last {
+			t.changeState(last, status.Status)
+			last = status.Status
+		}
 		if status.Status == "running" {
-			return status.Host, status.Ip, nil
+			return KGPEndpoint{Host: status.Host, Ip: status.Ip}, nil
 		}
 
 		if time.Now().After(end) {
 			break
-		} else {
-			time.Sleep(time.Second)
+		} else if err := sleep(ctx, time.Second); err != nil {
+			return endpoint, err
 		}
 	}
 
-	return "", "", fmt.Errorf(
-		"Tunnel %s didn't come up after %s",
-		t.Id, timeout.String())
+	return endpoint, &TimeoutError{Id: t.ID(), Timeout: timeout}
+}
+
+//
+// Wait for `delay`, or until `ctx` is canceled and return its error.
+//
+func sleep(ctx context.Context, delay time.Duration) error {
+	var timer = time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+//
+// Return whether `err` may go away by itself: the REST API couldn't be
+// reached, answered with a 5xx status, or the circuit is open.
+//
+func transient(err error) bool {
+	var connErr *ConnectionError
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &connErr):
+		return true
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode >= 500
+	}
+	return false
 }
 
-func (t *Tunnel) Shutdown() (int, error) {
-	return t.Client.shutdown("%s/%s/tunnels/%s?wait_for_jobs=0", t.Id)
+//
+// Error returned when a tunnel didn't reach the "running" state in time.
+//
+type TimeoutError struct {
+	Id      string
+	Timeout time.Duration
 }
 
-func (t *Tunnel) ShutdownWaitForJobs() (int, error) {
-	return t.Client.shutdown("%s/%s/tunnels/%s?wait_for_jobs=1", t.Id)
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf(
+		"Tunnel %s didn't come up after %s", e.Id, e.Timeout.String())
 }
 
-type serverStatus struct {
-	Status       string `json:"status"`
-	UserShutdown *bool  `json:"user_shutdown"`
-	Ip           string `json:"ip_address"`
-	Host         string `json:"host"`
+func (t *Tunnel) Shutdown() (jobsRunning int, err error) {
+	ctx, span := t.Client.startSpan(context.Background(),
+		"Tunnel.Shutdown", Attr("tunnel", t.ID()))
+	defer func() { span.End(err) }()
+
+	return t.Client.shutdown(ctx, t.Client.Username, t.ID(), waitForJobs(false))
 }
 
-func (c *Client) status(id string) (status serverStatus, err error) {
-	status = serverStatus{Ip: ""}
-	var url = fmt.Sprintf("%s/%s/tunnels/%s", c.BaseURL, c.Username, id)
+func (t *Tunnel) ShutdownWaitForJobs() (jobsRunning int, err error) {
+	ctx, span := t.Client.startSpan(context.Background(),
+		"Tunnel.ShutdownWaitForJobs", Attr("tunnel", t.ID()))
+	defer func() { span.End(err) }()
 
-	err = c.executeRequest("GET", url, nil, &status)
+	return t.Client.shutdown(ctx, t.Client.Username, t.ID(), waitForJobs(true))
+}
+
+func (c *Client) status(
+	ctx context.Context, id string,
+) (status TunnelInfo, err error) {
+	url, err := c.restURL(nil, c.Username, "tunnels", id)
+	if err != nil {
+		return
+	}
+
+	err = c.executeRequest(ctx, "GET", url, nil, &status)
+	if err == nil {
+		c.metrics().TunnelStatus(id, status.State())
+	}
 	return
 }
 
+//
+// Return the full state of tunnel `id`
+//
+func (c *Client) Info(id string) (info TunnelInfo, err error) {
+	return c.info(context.Background(), id)
+}
+
+func (c *Client) info(
+	ctx context.Context, id string,
+) (info TunnelInfo, err error) {
+	ctx, span := c.startSpan(ctx, "Client.Info", Attr("tunnel", id))
+	defer func() { span.End(err) }()
+
+	return c.status(ctx, id)
+}
+
 //
 // status can have the values:
 // - "running" the tunnel is up and running
@@ -545,22 +1238,26 @@ func (c *Client) status(id string) (status serverStatus, err error) {
 func (c *Client) Status(id string) (
 	status string, err error,
 ) {
-	s, err := c.status(id)
+	ctx, span := c.startSpan(context.Background(),
+		"Client.Status", Attr("tunnel", id))
+	defer func() { span.End(err) }()
+
+	s, err := c.status(ctx, id)
 	if err != nil {
 		return
 	}
 
-	if s.UserShutdown != nil && *s.UserShutdown {
-		status = "user shutdown"
-	} else {
-		status = s.Status
-	}
+	status = s.State()
 
 	return
 }
 
-func (c *Client) KgpHost(id string) (string, string, error) {
-	var s, err = c.status(id)
+func (c *Client) KgpHost(id string) (host string, ip string, err error) {
+	ctx, span := c.startSpan(context.Background(),
+		"Client.KgpHost", Attr("tunnel", id))
+	defer func() { span.End(err) }()
+
+	s, err := c.status(ctx, id)
 	if err != nil {
 		return "", "", err
 	}
@@ -568,10 +1265,18 @@ func (c *Client) KgpHost(id string) (string, string, error) {
 	return s.Host, s.Ip, nil
 }
 
+//
+// Query the status of the tunnel, see Client.Status for the values. State
+// returns the last one known without a request.
+//
 func (t *Tunnel) Status() (
 	status string, err error,
 ) {
-	return t.Client.Status(t.Id)
+	status, err = t.Client.Status(t.ID())
+	if err == nil {
+		t.setState(status)
+	}
+	return
 }
 
 type heartBeatRequest struct {
@@ -586,8 +1291,24 @@ func (c *Client) Ping(
 	id string,
 	connected bool,
 	duration time.Duration,
-) error {
-	var url = fmt.Sprintf("%s/%s/tunnels/%s/connected", c.BaseURL, c.Username, id)
+) (err error) {
+	return c.ping(context.Background(), id, connected, duration)
+}
+
+func (c *Client) ping(
+	ctx context.Context,
+	id string,
+	connected bool,
+	duration time.Duration,
+) (err error) {
+	ctx, span := c.startSpan(ctx, "Client.Ping",
+		Attr("tunnel", id), Attr("kgp_connected", connected))
+	defer func() { span.End(err) }()
+
+	url, err := c.restURL(nil, c.Username, "tunnels", id, "connected")
+	if err != nil {
+		return
+	}
 
 	var h = heartBeatRequest{
 		KGPConnected:         connected,
@@ -601,5 +1322,8 @@ func (c *Client) Ping(
 	// We don't decode it since it doesn't give us any useful information to
 	// return. It looks like result is always true looking at the REST backend
 	// code.
-	return c.executeRequest("POST", url, &h, nil)
+	err = c.executeRequest(ctx, "POST", url, &h, nil)
+	c.metrics().Heartbeat(id, err)
+
+	return err
 }
@@ -1,12 +1,15 @@
 package rest
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -421,7 +424,7 @@ const (
 	statusShutdownJSON      = `{"status": "shutdown", "user_shutdown": null, "host": "HOSTNAME"}`
 )
 
-func createTunnel(url string) (Tunnel, error) {
+func createTunnel(url string) (*Tunnel, error) {
 	var client = Client{
 		BaseURL:  url,
 		Username: "username",
@@ -430,7 +433,7 @@ func createTunnel(url string) (Tunnel, error) {
 	var request = Request{
 		DomainNames: []string{"sauce-connect.proxy"},
 	}
-	return client.CreateWithTimeout(&request, 1*time.Second)
+	return client.CreateTunnelWithTimeout(&request, 1*time.Second)
 }
 
 func TestClientCreate(t *testing.T) {
@@ -446,6 +449,34 @@ func TestClientCreate(t *testing.T) {
 	}
 }
 
+// The copies of the tunnel share its state
+func TestClientCreateWithTimeoutValue(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		stringResponse(statusRunningJSON),
+	})
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+	tunnel, err := client.CreateWithTimeout(&Request{}, time.Second)
+	if err != nil {
+		t.Fatalf("client.CreateWithTimeout errored %+v\n", err)
+	}
+	if state := tunnel.State(); state != "running" {
+		t.Errorf("Got state %s, expected running", state)
+	}
+
+	var copied = tunnel
+	copied.setState("shutdown")
+	if state := tunnel.State(); state != "shutdown" {
+		t.Errorf("Got state %s after changing the copy, expected shutdown", state)
+	}
+}
+
 func TestClientCreateNoIpReceived(t *testing.T) {
 	// In case REST implementation doesn't return ip_address yet
 	var server = multiResponseServer([]R{
@@ -589,6 +620,58 @@ func TestTunnelLoop(t *testing.T) {
 	}
 }
 
+// The changes of status are sent to StateChanges, from creation to shutdown
+func TestTunnelLoopStateChanges(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		stringResponse(`{"status": "booting"}`),
+		stringResponse(statusRunningJSON),
+		stringResponse(statusRunningJSON),
+		stringResponse(statusShutdownJSON),
+	})
+	defer server.Close()
+
+	tunnel, err := createTunnel(server.URL)
+	if err != nil {
+		t.Fatalf("client.createWithTimeout errored %+v\n", err)
+	}
+	go tunnel.serverStatusLoop(time.Millisecond)
+	<-tunnel.ServerStatus
+
+	for _, expected := range []StateChange{
+		{From: "new", To: "booting"},
+		{From: "booting", To: "running"},
+		{From: "running", To: "shutdown"},
+	} {
+		select {
+		case change := <-tunnel.StateChanges:
+			if change != expected {
+				t.Errorf("Got change %+v, expected %+v", change, expected)
+			}
+		default:
+			t.Errorf("Missing change %+v", expected)
+		}
+	}
+}
+
+// Only the latest changes are kept until received
+func TestTunnelChangeState(t *testing.T) {
+	var tunnel = Tunnel{
+		Client:       &Client{},
+		Id:           "fakeid",
+		StateChanges: make(chan StateChange, 2),
+	}
+	tunnel.changeState("new", "booting")
+	tunnel.changeState("booting", "running")
+	tunnel.changeState("running", "shutdown")
+
+	for _, expected := range []string{"running", "shutdown"} {
+		if change := <-tunnel.StateChanges; change.To != expected {
+			t.Errorf("Got change %+v, expected one to %s", change, expected)
+		}
+	}
+}
+
 func heartbeatChecker(
 	connected bool,
 	changeDuration int64,
@@ -623,8 +706,9 @@ func TestTunnelLoopClientStop(t *testing.T) {
 
 	tunnel, err := createTunnel(server.URL)
 	if err != nil {
-		t.Errorf("client.createWithTimeout errored %+v\n", err)
+		t.Fatalf("client.createWithTimeout errored %+v\n", err)
 	}
+	defer tunnel.Close()
 
 	var now = time.Now()
 	var before = now.Add(-1 * time.Second)
@@ -641,3 +725,325 @@ func TestTunnelLoopClientStop(t *testing.T) {
 		LastStatusChange: now.Unix(),
 	}
 }
+
+func TestClientListDetailed(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(`[` + createJSON + `]`),
+	})
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	tunnels, err := client.ListDetailed(ListOptions{})
+	if err != nil {
+		t.Fatalf("client.ListDetailed errored %+v\n", err)
+	}
+	if len(tunnels) != 1 {
+		t.Fatalf("client.ListDetailed returned %+v\n", tunnels)
+	}
+
+	var info = tunnels[0]
+	if info.Id != "49958ce5ec9f49c796542e0c691455a6" ||
+		info.Owner != "zwane" ||
+		info.CreationTime != 1467839998 ||
+		info.LaunchTime != nil ||
+		info.Metadata.Hostname != "Commodore64 Limited Edition" ||
+		!reflect.DeepEqual(info.DomainNames, []string{"sauce-connect.proxy"}) {
+		t.Errorf("Invalid tunnel info: %+v\n", info)
+	}
+}
+
+func TestClientInfoUserShutdown(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(`{"status": "running", "user_shutdown": true}`),
+	})
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	info, err := client.Info("fakeid")
+	if err != nil {
+		t.Fatalf("client.Info errored %+v\n", err)
+	}
+	if info.Status != "running" || info.State() != "user shutdown" {
+		t.Errorf("Invalid status %s, state %s\n", info.Status, info.State())
+	}
+}
+
+func TestClientHTTPErrorType(t *testing.T) {
+	var server = multiResponseServer([]R{
+		errorResponse(404, "nothing to see here"),
+	})
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	_, err := client.Status("fakeid")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("Invalid error type: %#v", err)
+	}
+	if httpErr.StatusCode != 404 ||
+		!strings.HasPrefix(httpErr.Body, "nothing to see here") {
+		t.Errorf("Invalid error: %+v", httpErr)
+	}
+}
+
+func TestClientConnectionErrorType(t *testing.T) {
+	var server = multiResponseServer([]R{})
+	server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	_, err := client.Status("fakeid")
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("Invalid error type: %#v", err)
+	}
+	if connErr.Unwrap() == nil {
+		t.Errorf("ConnectionError doesn't wrap the cause")
+	}
+}
+
+func TestClientCreateTimeoutErrorType(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		stringResponse(`{"status": "new", "user_shutdown": null}`),
+	})
+	defer server.Close()
+
+	tunnel, err := createTunnel(server.URL)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Invalid error type: %#v", err)
+	}
+	if timeoutErr.Id != "49958ce5ec9f49c796542e0c691455a6" ||
+		timeoutErr.Timeout != time.Second {
+		t.Errorf("Invalid error: %+v", timeoutErr)
+	}
+	// The tunnel still exists, the caller needs it to shut it down
+	if tunnel == nil || tunnel.ID() != timeoutErr.Id {
+		t.Errorf("Invalid tunnel %+v", tunnel)
+	}
+}
+
+// Canceling the context stops waiting for the tunnel
+func TestClientCreateAndMonitorContextCanceled(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(createJSON),
+		stringResponse(`{"status": "new", "user_shutdown": null}`),
+	})
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	var start = time.Now()
+	tunnel, err := client.CreateAndMonitorContext(ctx, &Request{}, time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Invalid error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Returned after %s", elapsed)
+	}
+	// The tunnel still exists, the caller needs it to shut it down
+	if tunnel == nil || tunnel.ID() != "49958ce5ec9f49c796542e0c691455a6" {
+		t.Errorf("Invalid tunnel %+v", tunnel)
+	}
+}
+
+func TestClientShutdownMany(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests = append(requests, r.Method+" "+r.URL.String())
+			mutex.Unlock()
+
+			switch {
+			case strings.Contains(r.URL.Path, "/gone"):
+				http.Error(w, `{"error": "no such tunnel"}`, 404)
+			case strings.Contains(r.URL.Path, "/broken"):
+				http.Error(w, "Not available", 503)
+			default:
+				io.WriteString(w, `{"jobs_running": 2}`)
+			}
+		}))
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	results, jobs, err := client.ShutdownMany(
+		[]string{"one", "gone", "two", "broken"},
+		ShutdownOptions{Concurrency: 2, WaitForJobs: true})
+
+	if jobs != 4 {
+		t.Errorf("Invalid jobs_running total: %d", jobs)
+	}
+	if err == nil || !strings.Contains(err.Error(), "tunnel broken: ") {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Invalid results: %+v", results)
+	}
+	for i, id := range []string{"one", "gone", "two", "broken"} {
+		if results[i].Id != id {
+			t.Errorf("Result %d is for %s, expected %s", i, results[i].Id, id)
+		}
+	}
+	if results[0].Err != nil || results[0].JobsRunning != 2 {
+		t.Errorf("Invalid result: %+v", results[0])
+	}
+	if results[1].Err != nil || !results[1].AlreadyTerminated {
+		t.Errorf("Invalid result: %+v", results[1])
+	}
+	var httpErr *HTTPError
+	if !errors.As(results[3].Err, &httpErr) || httpErr.StatusCode != 503 {
+		t.Errorf("Invalid result: %+v", results[3])
+	}
+	for _, r := range requests {
+		if !strings.HasPrefix(r, "DELETE ") ||
+			!strings.HasSuffix(r, "?wait_for_jobs=1") {
+			t.Errorf("Invalid request: %s", r)
+		}
+	}
+}
+
+func TestClientShutdownManyEmpty(t *testing.T) {
+	var client = Client{BaseURL: "http://127.0.0.1:0"}
+
+	results, jobs, err := client.ShutdownMany(nil, ShutdownOptions{})
+	if len(results) != 0 || jobs != 0 || err != nil {
+		t.Errorf("Invalid results: %+v %d %v", results, jobs, err)
+	}
+}
+
+func TestClientListDetailedOwner(t *testing.T) {
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.String() {
+			case "/admin/tunnels?full=1":
+				io.WriteString(w, `[{"id": "admin-tunnel"}]`)
+			case "/admin/tunnels?full=1&all=1":
+				io.WriteString(w, `[
+					{"id": "admin-tunnel"},
+					{"id": "shared-tunnel", "owner": "dev"}]`)
+			default:
+				http.Error(w, "Not found", 404)
+			}
+		}))
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	for _, test := range []struct {
+		opts     ListOptions
+		expected []string
+	}{
+		{
+			// The tunnels without an owner are the ones of the owner queried
+			opts:     ListOptions{Owner: "admin"},
+			expected: []string{"admin-tunnel:admin"},
+		},
+		{
+			opts:     ListOptions{Owner: "admin", IncludeShared: true},
+			expected: []string{"admin-tunnel:admin", "shared-tunnel:dev"},
+		},
+	} {
+		tunnels, err := client.ListDetailed(test.opts)
+		if err != nil {
+			t.Fatalf("%+v: client.ListDetailed errored %+v\n", test.opts, err)
+		}
+
+		var owners []string
+		for _, info := range tunnels {
+			owners = append(owners, info.Id+":"+info.Owner)
+		}
+		if !reflect.DeepEqual(owners, test.expected) {
+			t.Errorf("%+v: client.ListDetailed returned %v, expected %v",
+				test.opts, owners, test.expected)
+		}
+	}
+}
+
+func TestClientFindDetailedOwner(t *testing.T) {
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.String() != "/other/tunnels?full=1" {
+				http.Error(w, "Not found", 404)
+				return
+			}
+			io.WriteString(w, listTunnelJSON)
+		}))
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	matches, err := client.FindDetailed(
+		"fakename", nil, ListOptions{Owner: "other"})
+	if err != nil {
+		t.Fatalf("client.FindDetailed errored %+v\n", err)
+	}
+	if len(matches) != 1 || matches[0].Id != "fakeid" ||
+		matches[0].Owner != "other" {
+		t.Errorf("client.FindDetailed returned %+v\n", matches)
+	}
+}
+
+func TestClientShutdownManyOwner(t *testing.T) {
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/other/tunnels/fakeid" {
+				http.Error(w, "Bad request", 400)
+				return
+			}
+			io.WriteString(w, `{"jobs_running": 0}`)
+		}))
+	defer server.Close()
+
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+	}
+
+	_, _, err := client.ShutdownMany(
+		[]string{"fakeid"}, ShutdownOptions{Owner: "other"})
+	if err != nil {
+		t.Errorf("client.ShutdownMany errored %+v\n", err)
+	}
+}
@@ -0,0 +1,159 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//
+// TLS settings of the connections to the REST API, for the networks that
+// intercept TLS with their own CA or require client certificates.
+//
+type TLSOptions struct {
+	// PEM file of CAs to trust, on top of the system ones.
+	CAFile string
+	// PEM files of the client certificate and of its key. The key is read
+	// from ClientCert if ClientKey is empty.
+	ClientCert string
+	ClientKey  string
+	// Minimum TLS version, tls.VersionTLS12 if zero.
+	MinVersion uint16
+	// SHA-256 hashes of the public keys (SPKI) one of the certificates of
+	// PinnedHost must have, base64 encoded with an optional "sha256/" prefix.
+	// Every host is pinned if PinnedHost is empty, and so are the hosts
+	// reached by IP address, which don't send their name.
+	Pins       []string
+	PinnedHost string
+}
+
+//
+// Return the TLS version named `name`, "1.2" for instance.
+//
+func ParseTLSVersion(name string) (uint16, error) {
+	switch name {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %s, expected 1.0 to 1.3", name)
+}
+
+//
+// Return the base64 SHA-256 hash of the public key of `cert`, as given in
+// TLSOptions.Pins.
+//
+func PublicKeyPin(cert *x509.Certificate) string {
+	var sum = sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//
+// Return the TLS configuration of the options.
+//
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	var config = &tls.Config{MinVersion: o.MinVersion}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if o.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.ClientCert == "" && o.ClientKey != "" {
+		return nil, fmt.Errorf("client key given without a certificate")
+	}
+	if o.ClientCert != "" {
+		var key = o.ClientKey
+		if key == "" {
+			key = o.ClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.Pins) > 0 {
+		var pins = map[string]bool{}
+		for _, pin := range o.Pins {
+			pin = strings.TrimPrefix(pin, "sha256/")
+			if sum, err := base64.StdEncoding.DecodeString(pin); err != nil ||
+				len(sum) != sha256.Size {
+				return nil, fmt.Errorf("invalid pin %s, expected the "+
+					"base64 SHA-256 hash of a public key", pin)
+			}
+			pins[pin] = true
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if o.PinnedHost != "" && state.ServerName != "" &&
+				state.ServerName != o.PinnedHost {
+				return nil
+			}
+			for _, cert := range state.PeerCertificates {
+				if pins[PublicKeyPin(cert)] {
+					return nil
+				}
+			}
+			return fmt.Errorf("no public key of %s matches the pins",
+				state.ServerName)
+		}
+	}
+
+	return config, nil
+}
+
+//
+// Return an HTTP transport using the options, and the proxy of the
+// environment.
+//
+func (o *TLSOptions) Transport() (*http.Transport, error) {
+	config, err := o.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var transport = http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+//
+// Return a function sending the requests with the options, to use as
+// Client.ExecuteRequest.
+//
+func (o *TLSOptions) ExecuteRequest() (
+	func(*http.Request) (*http.Response, error), error,
+) {
+	transport, err := o.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	var client = &http.Client{Transport: transport}
+	return client.Do, nil
+}
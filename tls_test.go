@@ -0,0 +1,194 @@
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write the PEM `blocks` to a file in `dir` and return its path
+func writePEM(t *testing.T, dir, name string, blocks ...*pem.Block) string {
+	var path = filepath.Join(dir, name)
+	var f, err = os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, block := range blocks {
+		pem.Encode(f, block)
+	}
+	return path
+}
+
+// TLS server answering statusRunningJSON, and a PEM file of its certificate
+func tlsServer(t *testing.T, config *tls.Config) (*httptest.Server, string) {
+	var server = httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, statusRunningJSON)
+		}))
+	server.TLS = config
+	// Don't log the failed handshakes
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+
+	var caFile = writePEM(t, t.TempDir(), "ca.pem",
+		&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return server, caFile
+}
+
+func statusWithTLS(t *testing.T, server *httptest.Server, o TLSOptions) error {
+	execute, err := o.ExecuteRequest()
+	if err != nil {
+		t.Fatalf("ExecuteRequest errored %+v\n", err)
+	}
+	var client = Client{BaseURL: server.URL, Username: "username", ExecuteRequest: execute}
+	_, err = client.Status("fakeid")
+	return err
+}
+
+func TestTLSOptionsCAFile(t *testing.T) {
+	var server, caFile = tlsServer(t, nil)
+	defer server.Close()
+
+	if err := statusWithTLS(t, server, TLSOptions{}); err == nil {
+		t.Errorf("Unknown CA accepted")
+	}
+	if err := statusWithTLS(t, server, TLSOptions{CAFile: caFile}); err != nil {
+		t.Errorf("client.Status errored %+v\n", err)
+	}
+
+	var empty = writePEM(t, t.TempDir(), "empty.pem")
+	if _, err := (&TLSOptions{CAFile: empty}).Config(); err == nil {
+		t.Errorf("Empty CA file accepted")
+	}
+}
+
+func TestTLSOptionsPins(t *testing.T) {
+	var server, caFile = tlsServer(t, nil)
+	defer server.Close()
+
+	var pin = PublicKeyPin(server.Certificate())
+	var other = "sha256/" + strings.Repeat("A", 43) + "="
+
+	for _, c := range []struct {
+		options TLSOptions
+		ok      bool
+	}{
+		{TLSOptions{Pins: []string{pin}}, true},
+		{TLSOptions{Pins: []string{other, "sha256/" + pin}}, true},
+		{TLSOptions{Pins: []string{other}}, false},
+		{TLSOptions{Pins: []string{other}, PinnedHost: "example.com"}, false},
+		{TLSOptions{Pins: []string{other}, PinnedHost: "saucelabs.com"}, true},
+	} {
+		c.options.CAFile = caFile
+		transport, err := c.options.Transport()
+		if err != nil {
+			t.Fatalf("Transport errored %+v\n", err)
+		}
+		// The certificate of the server is for example.com
+		transport.DialContext = func(
+			ctx context.Context, network, _ string,
+		) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+		}
+		var client = Client{
+			BaseURL:        strings.Replace(server.URL, "127.0.0.1", "example.com", 1),
+			Username:       "username",
+			ExecuteRequest: (&http.Client{Transport: transport}).Do,
+		}
+
+		_, err = client.Status("fakeid")
+		if c.ok && err != nil {
+			t.Errorf("%+v: errored %s", c.options, err)
+		} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "pins")) {
+			t.Errorf("%+v: invalid error %v", c.options, err)
+		}
+	}
+
+	// Hosts reached by IP address are always pinned
+	var o = TLSOptions{CAFile: caFile, Pins: []string{other}, PinnedHost: "saucelabs.com"}
+	if err := statusWithTLS(t, server, o); err == nil {
+		t.Errorf("127.0.0.1 wasn't pinned")
+	}
+
+	if _, err := (&TLSOptions{Pins: []string{"tooshort"}}).Config(); err == nil {
+		t.Errorf("Invalid pin accepted")
+	}
+}
+
+func TestTLSOptionsClientCert(t *testing.T) {
+	var server, caFile = tlsServer(t,
+		&tls.Config{ClientAuth: tls.RequireAnyClientCert})
+	defer server.Close()
+
+	if err := statusWithTLS(t, server, TLSOptions{CAFile: caFile}); err == nil {
+		t.Errorf("Request without client certificate accepted")
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var template = x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, &template, &template,
+		&key.PublicKey, key)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	var dir = t.TempDir()
+	var certBlock = &pem.Block{Type: "CERTIFICATE", Bytes: der}
+	var keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}
+	var certFile = writePEM(t, dir, "cert.pem", certBlock)
+	var keyFile = writePEM(t, dir, "key.pem", keyBlock)
+	var bothFile = writePEM(t, dir, "both.pem", certBlock, keyBlock)
+
+	for _, o := range []TLSOptions{
+		{CAFile: caFile, ClientCert: certFile, ClientKey: keyFile},
+		{CAFile: caFile, ClientCert: bothFile},
+	} {
+		if err := statusWithTLS(t, server, o); err != nil {
+			t.Errorf("%+v: client.Status errored %+v\n", o, err)
+		}
+	}
+
+	if _, err := (&TLSOptions{ClientKey: keyFile}).Config(); err == nil {
+		t.Errorf("Key without certificate accepted")
+	}
+}
+
+func TestTLSOptionsMinVersion(t *testing.T) {
+	var server, caFile = tlsServer(t, &tls.Config{MaxVersion: tls.VersionTLS12})
+	defer server.Close()
+
+	version, err := ParseTLSVersion("1.3")
+	if err != nil {
+		t.Fatalf("ParseTLSVersion errored %+v\n", err)
+	}
+	var o = TLSOptions{CAFile: caFile, MinVersion: version}
+	if err := statusWithTLS(t, server, o); err == nil {
+		t.Errorf("TLS 1.2 accepted")
+	}
+
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Errorf("ParseTLSVersion didn't error")
+	}
+}
@@ -0,0 +1,222 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+//
+// Creates the spans of the traces of a Client, set it in Client.Tracer.
+// Every public method of Client gets a span, with a child span for each HTTP
+// request it sends. oteltracing.Tracer sends the spans to OpenTelemetry,
+// RecordingTracer keeps them in memory for tests.
+//
+type Tracer interface {
+	// Start a span named `name`, child of the span held by `ctx` if any,
+	// and return a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	AddEvent(name string, attrs ...Attribute)
+	// Add the trace context of the span to the headers of a request, the
+	// W3C traceparent header for instance.
+	Inject(header http.Header)
+	// End the span, failed with `err` if not nil.
+	End(err error)
+}
+
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type noopTracer struct{}
+type noopSpan struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttributes(...Attribute)    {}
+func (noopSpan) AddEvent(string, ...Attribute) {}
+func (noopSpan) Inject(http.Header)            {}
+func (noopSpan) End(error)                     {}
+
+//
+// Start a span with the tracer of the client, a no-op one if unset.
+//
+func (c *Client) startSpan(
+	ctx context.Context, name string, attrs ...Attribute,
+) (context.Context, Span) {
+	var tracer Tracer = noopTracer{}
+	if c.Tracer != nil {
+		tracer = c.Tracer
+	}
+
+	ctx, span := tracer.Start(ctx, name)
+	span.SetAttributes(attrs...)
+
+	return ctx, span
+}
+
+//
+// Return `ctx` with hooks adding the connection events of the HTTP request
+// to `span`, to tell the DNS or the TLS handshake from the REST API itself.
+//
+func withConnectionEvents(ctx context.Context, span Span) context.Context {
+	var errAttrs = func(err error) []Attribute {
+		if err != nil {
+			return []Attribute{Attr("error", err.Error())}
+		}
+		return nil
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			span.AddEvent("dns start", Attr("host", info.Host))
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			span.AddEvent("dns done", errAttrs(info.Err)...)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			span.AddEvent("connect done",
+				append(errAttrs(err), Attr("address", addr))...)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			span.AddEvent("tls handshake done", errAttrs(err)...)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			span.AddEvent("got connection", Attr("reused", info.Reused))
+		},
+		GotFirstResponseByte: func() {
+			span.AddEvent("first response byte")
+		},
+	})
+}
+
+//
+// Tracer keeping the spans in memory, for tests. The spans get W3C trace
+// context ids, and Inject sets the traceparent header.
+//
+type RecordingTracer struct {
+	mutex sync.Mutex
+	spans []*RecordedSpan
+}
+
+type RecordedSpan struct {
+	Name       string
+	TraceId    string
+	SpanId     string
+	ParentId   string // Empty for root spans
+	Attributes map[string]interface{}
+	Events     []RecordedEvent
+	StartTime  time.Time
+	EndTime    time.Time // Zero until the span ends
+	Err        error
+
+	tracer *RecordingTracer
+}
+
+type RecordedEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+type recordedSpanKey struct{}
+
+func randomHex(n int) string {
+	var b = make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (t *RecordingTracer) Start(
+	ctx context.Context, name string,
+) (context.Context, Span) {
+	var span = &RecordedSpan{
+		Name:       name,
+		TraceId:    randomHex(16),
+		SpanId:     randomHex(8),
+		Attributes: map[string]interface{}{},
+		StartTime:  time.Now(),
+		tracer:     t,
+	}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*RecordedSpan); ok {
+		span.TraceId = parent.TraceId
+		span.ParentId = parent.SpanId
+	}
+
+	t.mutex.Lock()
+	t.spans = append(t.spans, span)
+	t.mutex.Unlock()
+
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+//
+// Return a copy of the spans started so far, in the order they started.
+//
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var spans = make([]RecordedSpan, len(t.spans))
+	for i, span := range t.spans {
+		spans[i] = *span
+		spans[i].Attributes = map[string]interface{}{}
+		for key, value := range span.Attributes {
+			spans[i].Attributes[key] = value
+		}
+		spans[i].Events = append([]RecordedEvent{}, span.Events...)
+	}
+
+	return spans
+}
+
+func (s *RecordedSpan) SetAttributes(attrs ...Attribute) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	for _, attr := range attrs {
+		s.Attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *RecordedSpan) AddEvent(name string, attrs ...Attribute) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	var event = RecordedEvent{
+		Name:       name,
+		Time:       time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+	for _, attr := range attrs {
+		event.Attributes[attr.Key] = attr.Value
+	}
+	s.Events = append(s.Events, event)
+}
+
+func (s *RecordedSpan) Inject(header http.Header) {
+	header.Set("traceparent", "00-"+s.TraceId+"-"+s.SpanId+"-01")
+}
+
+func (s *RecordedSpan) End(err error) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	s.EndTime, s.Err = time.Now(), err
+}
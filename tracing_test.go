@@ -0,0 +1,208 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Return the spans named `name`
+func spansNamed(spans []RecordedSpan, name string) (found []RecordedSpan) {
+	for _, span := range spans {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	return
+}
+
+func TestClientTracerCreate(t *testing.T) {
+	var mutex sync.Mutex
+	var traceparents []string
+	var responses = []string{createJSON, statusRunningJSON}
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			traceparents = append(traceparents, r.Header.Get("traceparent"))
+			io.WriteString(w, responses[len(traceparents)-1])
+		}))
+	defer server.Close()
+
+	var tracer = &RecordingTracer{}
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Password: "password",
+		Tracer:   tracer,
+	}
+	tunnel, err := client.CreateTunnelWithTimeout(
+		&Request{TunnelIdentifier: "ci"}, time.Second)
+	if err != nil {
+		t.Fatalf("client.CreateWithTimeout errored %+v\n", err)
+	}
+
+	var spans = tracer.Spans()
+	if len(spans) != 4 {
+		t.Fatalf("Expected 4 spans, got %+v", spans)
+	}
+	var root, post, wait, get = spans[0], spans[1], spans[2], spans[3]
+
+	if root.Name != "Client.CreateTunnelWithTimeout" || root.ParentId != "" ||
+		root.Attributes["tunnel_identifier"] != "ci" || root.EndTime.IsZero() {
+		t.Errorf("Invalid root span %+v", root)
+	}
+	if post.Name != "HTTP POST" || post.ParentId != root.SpanId ||
+		post.Attributes["http.status_code"] != 200 ||
+		post.Attributes["attempt"] != 1 {
+		t.Errorf("Invalid POST span %+v", post)
+	}
+	if wait.Name != "Tunnel.wait" || wait.ParentId != root.SpanId ||
+		wait.Attributes["tunnel"] != tunnel.Id {
+		t.Errorf("Invalid wait span %+v", wait)
+	}
+	if len(wait.Events) != 1 || wait.Events[0].Name != "status" ||
+		wait.Events[0].Attributes["status"] != "running" {
+		t.Errorf("Invalid wait events %+v", wait.Events)
+	}
+	if get.Name != "HTTP GET" || get.ParentId != wait.SpanId {
+		t.Errorf("Invalid GET span %+v", get)
+	}
+	for _, span := range spans {
+		if span.TraceId != root.TraceId {
+			t.Errorf("Span %s isn't in the trace", span.Name)
+		}
+	}
+
+	// The trace context of the HTTP spans is propagated
+	var expected = []string{
+		"00-" + root.TraceId + "-" + post.SpanId + "-01",
+		"00-" + root.TraceId + "-" + get.SpanId + "-01",
+	}
+	if len(traceparents) != 2 ||
+		traceparents[0] != expected[0] || traceparents[1] != expected[1] {
+		t.Errorf("Invalid traceparent headers %v, expected %v",
+			traceparents, expected)
+	}
+
+	// Connection events tell the time spent connecting
+	var names = map[string]bool{}
+	for _, event := range post.Events {
+		names[event.Name] = true
+	}
+	if !names["connect done"] || !names["first response byte"] {
+		t.Errorf("Missing connection events in %+v", post.Events)
+	}
+}
+
+func TestClientTracerRetry(t *testing.T) {
+	var expected = "Bearer two"
+	var seen []string
+	var server = authServer(&expected, &seen)
+	defer server.Close()
+
+	var token = "one"
+	var tracer = &RecordingTracer{}
+	var client = Client{
+		BaseURL:  server.URL,
+		Username: "username",
+		Tracer:   tracer,
+		Auth: &CredentialProvider{
+			Fetch: func() (Credentials, error) {
+				var c = Credentials{Token: token}
+				token = "two"
+				return c, nil
+			},
+		},
+	}
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Fatalf("client.Status errored %+v\n", err)
+	}
+
+	var spans = tracer.Spans()
+	var root = spansNamed(spans, "Client.Status")
+	var attempts = spansNamed(spans, "HTTP GET")
+	if len(root) != 1 || len(attempts) != 2 {
+		t.Fatalf("Invalid spans %+v", spans)
+	}
+	for i, attempt := range attempts {
+		if attempt.ParentId != root[0].SpanId ||
+			attempt.Attributes["attempt"] != i+1 {
+			t.Errorf("Invalid attempt span %+v", attempt)
+		}
+	}
+	if attempts[0].Attributes["http.status_code"] != 401 ||
+		attempts[1].Attributes["http.status_code"] != 200 {
+		t.Errorf("Invalid status codes %+v", attempts)
+	}
+}
+
+func TestClientTracerErrors(t *testing.T) {
+	var server = multiResponseServer([]R{
+		errorResponse(500, "oops"),
+	})
+
+	var tracer = &RecordingTracer{}
+	var client = Client{BaseURL: server.URL, Username: "username", Tracer: tracer}
+	client.Info("fakeid")
+
+	var spans = spansNamed(tracer.Spans(), "Client.Info")
+	var httpErr *HTTPError
+	if len(spans) != 1 || !errors.As(spans[0].Err, &httpErr) {
+		t.Errorf("Invalid spans %+v", spans)
+	}
+
+	server.Close()
+	client.Ping("fakeid", true, time.Second)
+
+	spans = spansNamed(tracer.Spans(), "HTTP POST")
+	var connErr *ConnectionError
+	if len(spans) != 1 || !errors.As(spans[0].Err, &connErr) {
+		t.Errorf("Invalid spans %+v", spans)
+	}
+}
+
+func TestClientTracerShutdownMany(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(`{"jobs_running": 0}`),
+	})
+	defer server.Close()
+
+	var tracer = &RecordingTracer{}
+	var client = Client{BaseURL: server.URL, Username: "username", Tracer: tracer}
+	client.ShutdownMany([]string{"a", "b", "c"}, ShutdownOptions{})
+
+	var spans = tracer.Spans()
+	var root = spansNamed(spans, "Client.ShutdownMany")
+	var deletes = spansNamed(spans, "HTTP DELETE")
+	if len(root) != 1 || len(deletes) != 3 {
+		t.Fatalf("Invalid spans %+v", spans)
+	}
+	for _, span := range deletes {
+		if span.ParentId != root[0].SpanId {
+			t.Errorf("Invalid parent for %+v", span)
+		}
+	}
+}
+
+func TestClientNoTracer(t *testing.T) {
+	var traceparent = "unset"
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			io.WriteString(w, statusRunningJSON)
+		}))
+	defer server.Close()
+
+	var client = Client{BaseURL: server.URL, Username: "username"}
+	if _, err := client.Status("fakeid"); err != nil {
+		t.Fatalf("client.Status errored %+v\n", err)
+	}
+	if traceparent != "" {
+		t.Errorf("Unexpected traceparent header %s", traceparent)
+	}
+}
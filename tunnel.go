@@ -0,0 +1,292 @@
+package rest
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+//
+// Tunnel control interface. Create it by calling Client.CreateTunnel(), or get
+// one for an existing tunnel with Client.Tunnel(). A Tunnel is a handle shared
+// by pointer: its methods and accessors are safe to call across goroutines,
+// and State(), KGPEndpoint() and LastHeartbeat() are kept up to date by the
+// goroutines started by Client.CreateTunnel() until the tunnel is down or
+// closed. Copies of a tunnel made by the client share its state.
+//
+// Tunnel literals are only supported for compatibility: a literal gets its
+// state on the first call of one of its methods, and the copies made before
+// that don't share it.
+//
+// The exported fields are settings, set them before sharing the tunnel.
+//
+type Tunnel struct {
+	Client *Client
+	// Deprecated: use ID.
+	Id string
+	// Deprecated: Host and Ip are the address of the KGP server when the
+	// tunnel came up, use KGPEndpoint for the current one.
+	Host string
+	Ip   string
+	// Port of the KGP server, DefaultKGPPort if zero.
+	KGPPort int
+	// A channel used to communicate the state of the tunnel back to the main
+	// goroutine.
+	ServerStatus chan string
+	// Receives the status of the KGP client, for the heartbeats. Nothing
+	// receives it once the tunnel is closed: send it with SendClientStatus
+	// to not block then.
+	ClientStatus chan ClientStatus
+	// Receives the new address of the KGP server when the tunnel VM moves,
+	// so that the KGP client can reconnect. Only the latest address is kept
+	// until it is received.
+	KGPChanges chan KGPEndpoint
+	// Receives the changes of status of the tunnel, from the ones while it
+	// comes up to the one that takes it down. When full, the oldest change
+	// is dropped for the new one.
+	StateChanges chan StateChange
+	// Structured logs of the tunnel, the Logger of Client with the tunnel id
+	// if nil.
+	Logger *slog.Logger
+
+	tunnelId string
+	// Shared with the copies of the tunnel, use lock to get it
+	shared *tunnelState
+}
+
+//
+// Change of status of a tunnel, sent to Tunnel.StateChanges.
+//
+type StateChange struct {
+	From string
+	To   string
+}
+
+// Size of the StateChanges channels of the tunnels created by Client.Create
+const stateChangesSize = 16
+
+//
+// State of a tunnel, kept up to date by its goroutines.
+//
+type tunnelState struct {
+	mutex         sync.Mutex
+	state         string
+	endpoint      KGPEndpoint
+	lastHeartbeat time.Time
+	done          chan struct{}
+	// Context of the requests of the goroutines, canceled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+}
+
+// Held while creating the state of the Tunnel literals, the tunnels made by
+// the client get theirs right away
+var tunnelsMutex sync.Mutex
+
+//
+// Return a handle of the tunnel `id` of the user of the client, without
+// checking that it exists. Its state is unknown until Status is called.
+//
+func (c *Client) Tunnel(id string) *Tunnel {
+	return &Tunnel{Client: c, Id: id, tunnelId: id, shared: &tunnelState{}}
+}
+
+//
+// Return the state of the tunnel with its mutex held, creating it for the
+// Tunnel literals.
+//
+func (t *Tunnel) lock() *tunnelState {
+	tunnelsMutex.Lock()
+	if t.shared == nil {
+		t.shared = &tunnelState{}
+	}
+	var s = t.shared
+	tunnelsMutex.Unlock()
+
+	s.mutex.Lock()
+	return s
+}
+
+//
+// Id of the tunnel.
+//
+func (t *Tunnel) ID() string {
+	if t.tunnelId == "" {
+		return t.Id
+	}
+	return t.tunnelId
+}
+
+//
+// Last status of the tunnel seen by its status loop or by Status, empty if
+// none was.
+//
+func (t *Tunnel) State() string {
+	var s = t.lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+//
+// Record the status of the tunnel, and return the previous one.
+//
+func (t *Tunnel) setState(state string) (previous string) {
+	var s = t.lock()
+	defer s.mutex.Unlock()
+	previous, s.state = s.state, state
+	return
+}
+
+//
+// Time of the last heartbeat the REST API acknowledged, zero if none was.
+//
+func (t *Tunnel) LastHeartbeat() time.Time {
+	var s = t.lock()
+	defer s.mutex.Unlock()
+	return s.lastHeartbeat
+}
+
+//
+// Current address of the KGP server of the tunnel. It changes when the
+// backend migrates the tunnel VM, which the status loop of the tunnels
+// created by Client.Create notices.
+//
+func (t *Tunnel) KGPEndpoint() KGPEndpoint {
+	var s = t.lock()
+	defer s.mutex.Unlock()
+	return t.kgpEndpoint(s)
+}
+
+// The caller holds the mutex of `s`
+func (t *Tunnel) kgpEndpoint(s *tunnelState) KGPEndpoint {
+	if s.endpoint == (KGPEndpoint{}) {
+		return KGPEndpoint{Host: t.Host, Ip: t.Ip}
+	}
+	return s.endpoint
+}
+
+//
+// Record the address of the KGP server given by a status of the tunnel, and
+// send it to KGPChanges if it replaced a known one. Statuses without any
+// address don't count.
+//
+func (t *Tunnel) updateKGPEndpoint(endpoint KGPEndpoint) {
+	if endpoint.Host == "" && endpoint.Ip == "" {
+		return
+	}
+	var s = t.lock()
+	var previous = t.kgpEndpoint(s)
+	s.endpoint = endpoint
+	s.mutex.Unlock()
+	if previous == endpoint || (previous.Host == "" && previous.Ip == "") {
+		return
+	}
+
+	t.log().Info("KGP host changed",
+		"from_host", previous.Host, "from_ip", previous.Ip,
+		"to_host", endpoint.Host, "to_ip", endpoint.Ip)
+	if t.KGPChanges == nil {
+		return
+	}
+	// Replace the change nobody received yet, the status loop never blocks
+	for {
+		select {
+		case t.KGPChanges <- endpoint:
+			return
+		default:
+		}
+		select {
+		case <-t.KGPChanges:
+		default:
+		}
+	}
+}
+
+//
+// Log the change of status of the tunnel, and send it to StateChanges without
+// blocking.
+//
+func (t *Tunnel) changeState(from, to string) {
+	t.log().Info("Tunnel status changed", "from", from, "to", to)
+	if t.StateChanges == nil {
+		return
+	}
+	var change = StateChange{From: from, To: to}
+	// Drop the oldest change nobody received yet, the loops never block
+	for {
+		select {
+		case t.StateChanges <- change:
+			return
+		default:
+		}
+		select {
+		case <-t.StateChanges:
+		default:
+		}
+	}
+}
+
+//
+// Return a channel closed once the tunnel is closed.
+//
+func (t *Tunnel) Done() <-chan struct{} {
+	var s = t.lock()
+	defer s.mutex.Unlock()
+	return s.doneChannel()
+}
+
+// The caller holds the mutex
+func (s *tunnelState) doneChannel() chan struct{} {
+	if s.done == nil {
+		s.done = make(chan struct{})
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	return s.done
+}
+
+//
+// Return the context of the requests of the goroutines watching the tunnel,
+// canceled once it is closed.
+//
+func (t *Tunnel) context() context.Context {
+	var s = t.lock()
+	defer s.mutex.Unlock()
+	s.doneChannel()
+	return s.ctx
+}
+
+//
+// Send the status of the KGP client to the goroutine sending the heartbeats.
+// Return false without blocking if the tunnel is closed, or wasn't created
+// by Client.Create.
+//
+func (t *Tunnel) SendClientStatus(status ClientStatus) bool {
+	if t.ClientStatus == nil {
+		return false
+	}
+	select {
+	case t.ClientStatus <- status:
+		return true
+	case <-t.Done():
+		return false
+	}
+}
+
+//
+// Stop the goroutines watching the tunnel, and cancel the requests they are
+// sending, without shutting it down: call Shutdown for that. ServerStatus
+// and ClientStatus aren't received anymore once the tunnel is closed.
+// Closing a tunnel more than once does nothing.
+//
+func (t *Tunnel) Close() error {
+	var s = t.lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.doneChannel())
+		s.cancel()
+	}
+	return nil
+}
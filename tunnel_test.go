@@ -0,0 +1,210 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// REST API of a running tunnel, safe to query concurrently, counting the
+// requests in `count`
+func tunnelServer(count *int32) *httptest.Server {
+	return httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(count, 1)
+			switch {
+			case r.Method == "POST" && r.URL.Path == "/username/tunnels":
+				io.WriteString(w, createJSON)
+			case r.Method == "POST":
+				io.WriteString(w, `{"result": true}`)
+			case r.Method == "DELETE":
+				io.WriteString(w, `{"jobs_running": 0}`)
+			default:
+				io.WriteString(w, statusRunningJSON)
+			}
+		}))
+}
+
+// Run with -race
+func TestTunnelConcurrentUse(t *testing.T) {
+	var count int32
+	var server = tunnelServer(&count)
+	defer server.Close()
+
+	tunnel, err := createTunnel(server.URL)
+	if err != nil {
+		t.Fatalf("client.createWithTimeout errored %+v\n", err)
+	}
+	go tunnel.serverStatusLoop(time.Millisecond)
+	go tunnel.heartbeatLoop(time.Millisecond)
+
+	var deadline = time.Now().Add(time.Second)
+	for tunnel.LastHeartbeat().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if tunnel.LastHeartbeat().IsZero() {
+		t.Fatalf("No heartbeat sent")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := tunnel.Status(); err != nil {
+					t.Errorf("tunnel.Status errored %+v\n", err)
+				}
+				if _, err := tunnel.Shutdown(); err != nil {
+					t.Errorf("tunnel.Shutdown errored %+v\n", err)
+				}
+				if tunnel.ID() != "49958ce5ec9f49c796542e0c691455a6" ||
+					tunnel.State() != "running" ||
+					tunnel.KGPEndpoint().Host != "HOSTNAME" {
+					t.Errorf("Invalid tunnel %s %s %+v", tunnel.ID(),
+						tunnel.State(), tunnel.KGPEndpoint())
+				}
+				tunnel.SendClientStatus(ClientStatus{Connected: j%2 == 0})
+			}
+			if i%2 == 0 {
+				tunnel.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case <-tunnel.Done():
+	default:
+		t.Fatalf("The tunnel wasn't closed")
+	}
+	// The loops are done
+	time.Sleep(20 * time.Millisecond)
+	var before = atomic.LoadInt32(&count)
+	time.Sleep(20 * time.Millisecond)
+	if after := atomic.LoadInt32(&count); after != before {
+		t.Errorf("%d requests sent after Close", after-before)
+	}
+}
+
+// Closing releases the status loop waiting for ServerStatus to be received
+func TestTunnelCloseStatusLoop(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(statusShutdownJSON),
+	})
+	defer server.Close()
+
+	var tunnel = (&Client{BaseURL: server.URL, Username: "username"}).Tunnel("fakeid")
+	tunnel.ServerStatus = make(chan string)
+	var done = make(chan bool)
+	go func() {
+		tunnel.serverStatusLoop(time.Millisecond)
+		done <- true
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	tunnel.Close()
+	tunnel.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("serverStatusLoop didn't return")
+	}
+	if tunnel.State() != "shutdown" {
+		t.Errorf("Invalid state %s", tunnel.State())
+	}
+}
+
+// Closing cancels the requests of the loops, and the client statuses sent
+// after it don't block
+func TestTunnelCloseCancelsRequests(t *testing.T) {
+	var created = make(chan bool, 1)
+	var hang = make(chan struct{})
+	var server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" && r.URL.Path == "/username/tunnels" {
+				io.WriteString(w, createJSON)
+				return
+			}
+			select {
+			case created <- true:
+				io.WriteString(w, statusRunningJSON)
+			default:
+				// The requests of the loops never get an answer
+				select {
+				case <-hang:
+				case <-r.Context().Done():
+				}
+			}
+		}))
+	defer server.Close()
+	defer close(hang)
+
+	tunnel, err := createTunnel(server.URL)
+	if err != nil {
+		t.Fatalf("client.createWithTimeout errored %+v\n", err)
+	}
+	var done = make(chan bool, 2)
+	go func() {
+		tunnel.serverStatusLoop(time.Millisecond)
+		done <- true
+	}()
+	go func() {
+		tunnel.heartbeatLoop(time.Millisecond)
+		done <- true
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	tunnel.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("The loops didn't return")
+		}
+	}
+
+	var sent = make(chan bool)
+	go func() {
+		sent <- tunnel.SendClientStatus(ClientStatus{Connected: true})
+	}()
+	select {
+	case ok := <-sent:
+		if ok {
+			t.Errorf("Client status sent to a closed tunnel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("SendClientStatus blocked")
+	}
+}
+
+func TestClientTunnel(t *testing.T) {
+	var server = multiResponseServer([]R{
+		stringResponse(statusRunningJSON),
+	})
+	defer server.Close()
+
+	var tunnel = (&Client{BaseURL: server.URL, Username: "username"}).Tunnel("fakeid")
+	if tunnel.ID() != "fakeid" || tunnel.State() != "" ||
+		!tunnel.LastHeartbeat().IsZero() ||
+		tunnel.KGPEndpoint() != (KGPEndpoint{}) {
+		t.Errorf("Invalid tunnel %+v", tunnel)
+	}
+	if _, err := tunnel.Status(); err != nil {
+		t.Fatalf("tunnel.Status errored %+v\n", err)
+	}
+	if tunnel.State() != "running" {
+		t.Errorf("Invalid state %s", tunnel.State())
+	}
+
+	// The fields of the tunnels made before the accessors
+	var literal = Tunnel{Id: "fakeid", Host: "HOSTNAME", Ip: "1.2.3.4"}
+	if literal.ID() != "fakeid" ||
+		literal.KGPEndpoint() != (KGPEndpoint{Host: "HOSTNAME", Ip: "1.2.3.4"}) {
+		t.Errorf("Invalid tunnel %s %+v", literal.ID(), literal.KGPEndpoint())
+	}
+}
@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+//
+// Return the URL made of `base`, the path `segments` and the query `query`.
+// The segments are escaped, slashes included, so usernames like
+// "john+ci@example.com" or odd tunnel ids can't change the path. Empty, "."
+// and ".." segments are rejected for the same reason.
+//
+func joinURL(base string, query queryParams, segments ...string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %s", base, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid URL %q: scheme or host missing", base)
+	}
+
+	var path = strings.TrimSuffix(u.EscapedPath(), "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid path segment %q", segment)
+		}
+		path += "/" + escapeSegment(segment)
+	}
+	u.Path, err = url.PathUnescape(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %s", base, err)
+	}
+	u.RawPath = path
+	u.RawQuery = query.encode()
+	u.Fragment = ""
+
+	return u.String(), nil
+}
+
+//
+// Query parameters: name and value pairs, kept in order unlike url.Values.
+//
+type queryParams [][2]string
+
+func (q queryParams) encode() string {
+	var params []string
+	for _, param := range q {
+		params = append(params,
+			url.QueryEscape(param[0])+"="+url.QueryEscape(param[1]))
+	}
+	return strings.Join(params, "&")
+}
+
+//
+// Escape everything but the unreserved characters of `segment`: the API
+// doesn't have to guess what '+' or '@' mean in a path.
+//
+func escapeSegment(segment string) string {
+	return strings.ReplaceAll(url.QueryEscape(segment), "+", "%20")
+}
+
+//
+// Return the URL of the REST API endpoint with the path `segments`, relative
+// to BaseURL, and the query `query`.
+//
+func (c *Client) restURL(query queryParams, segments ...string) (string, error) {
+	return joinURL(c.BaseURL, query, segments...)
+}
+
+//
+// Return the query of the shutdown requests.
+//
+func waitForJobs(wait bool) queryParams {
+	if wait {
+		return queryParams{{"wait_for_jobs", "1"}}
+	}
+	return queryParams{{"wait_for_jobs", "0"}}
+}
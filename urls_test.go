@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJoinURL(t *testing.T) {
+	for _, c := range []struct {
+		base     string
+		query    queryParams
+		segments []string
+		expected string
+	}{
+		{"https://saucelabs.com/rest/v1", nil,
+			[]string{"john", "tunnels"},
+			"https://saucelabs.com/rest/v1/john/tunnels"},
+		{"https://saucelabs.com/rest/v1/", nil,
+			[]string{"john", "tunnels"},
+			"https://saucelabs.com/rest/v1/john/tunnels"},
+		{"https://saucelabs.com", nil,
+			[]string{"versions.json"},
+			"https://saucelabs.com/versions.json"},
+		{"https://saucelabs.com/rest/v1", nil,
+			[]string{"john+ci@example.com", "tunnels"},
+			"https://saucelabs.com/rest/v1/john%2Bci%40example.com/tunnels"},
+		{"https://saucelabs.com/rest/v1", nil,
+			[]string{"john doe", "tunnels", "a/b?c#d"},
+			"https://saucelabs.com/rest/v1/john%20doe/tunnels/a%2Fb%3Fc%23d"},
+		{"https://saucelabs.com/rest/v1", queryParams{{"full", "1"}, {"all", "1"}},
+			[]string{"john", "tunnels"},
+			"https://saucelabs.com/rest/v1/john/tunnels?full=1&all=1"},
+		{"https://saucelabs.com/rest/v1?ignored=1", queryParams{{"a b", "c&d"}},
+			[]string{"john"},
+			"https://saucelabs.com/rest/v1/john?a+b=c%26d"},
+		{"http://localhost:8080/some%2Fproxy/", nil,
+			[]string{"john"},
+			"http://localhost:8080/some%2Fproxy/john"},
+	} {
+		u, err := joinURL(c.base, c.query, c.segments...)
+		if err != nil {
+			t.Errorf("%s %v: errored %s", c.base, c.segments, err)
+		} else if u != c.expected {
+			t.Errorf("%s %v: got %s, expected %s",
+				c.base, c.segments, u, c.expected)
+		}
+	}
+}
+
+func TestJoinURLErrors(t *testing.T) {
+	for _, base := range []string{"", "saucelabs.com/rest/v1", "http://[::1", "%zz"} {
+		if u, err := joinURL(base, nil, "john"); err == nil {
+			t.Errorf("%q: got %s, expected an error", base, u)
+		}
+	}
+
+	// The segments that would change the path
+	for _, segment := range []string{"", ".", ".."} {
+		u, err := joinURL("https://saucelabs.com/rest/v1", nil,
+			"john", "tunnels", segment)
+		if err == nil || !strings.Contains(err.Error(), "invalid path segment") {
+			t.Errorf("%q: got %s %v, expected an error", segment, u, err)
+		}
+	}
+}
+
+// The requests reach the server with the path segments intact
+func TestClientAwkwardUsernames(t *testing.T) {
+	for _, username := range []string{
+		"john+ci@example.com", "john doe", "john/doe", "john%20doe",
+	} {
+		var path string
+		var server = httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.EscapedPath()
+				w.Write([]byte(statusRunningJSON))
+			}))
+
+		var client = Client{BaseURL: server.URL + "/rest/v1/", Username: username}
+		if _, err := client.Status("tunnel/id"); err != nil {
+			t.Errorf("%s: client.Status errored %+v\n", username, err)
+		}
+		server.Close()
+
+		var expected = "/rest/v1/" + escapeSegment(username) + "/tunnels/tunnel%2Fid"
+		if path != expected {
+			t.Errorf("%s: got path %s, expected %s", username, path, expected)
+		}
+	}
+}
+
+func TestGetLastVersionInvalidURL(t *testing.T) {
+	var client = Client{}
+	_, _, err := client.GetLastVersionFromURL("http://[::1")
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid URL") {
+		t.Errorf("Invalid error %v", err)
+	}
+}